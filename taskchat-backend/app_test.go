@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"taskchat/config"
+	"taskchat/database"
+	"taskchat/events"
+	"taskchat/handlers"
+	"taskchat/presence"
+	"taskchat/repository"
+	"taskchat/utils"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	testPassword   = "secret123"
+	testAdminEmail = "admin@example.com"
+)
+
+func TestMain(m *testing.M) {
+	// handlers log every expected 404 and 401, keep the test output readable
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testApp is the real route table served in process against a throwaway
+// in-memory sqlite database
+type testApp struct {
+	t       *testing.T
+	app     *fiber.App
+	db      *gorm.DB
+	hub     *events.Broker
+	tracker *presence.Tracker
+}
+
+// testUser is a registered account and the token it was issued
+type testUser struct {
+	ID    string
+	Email string
+	Token string
+}
+
+// apiResponse is the standard envelope every handler replies with
+type apiResponse struct {
+	Status  int             `json:"-"`
+	Success bool            `json:"success"`
+	Data    json.RawMessage `json:"data"`
+	Error   string          `json:"error"`
+	Body    []byte          `json:"-"`
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	utils.ConfigureJWT("integration-test-secret", time.Hour)
+
+	// every connection to :memory: is a new database, Open keeps exactly one open
+	db, err := database.Open(config.DatabaseConfig{Driver: database.DriverSQLite, URL: ":memory:"})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate db: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true, ErrorHandler: handlers.ErrorHandler})
+	// events and presence are kept per app so tests do not see each other's
+	bus := events.NewMemoryBus()
+	hub := events.NewBroker(bus, events.DefaultHistorySize)
+	tracker := presence.NewTracker(bus, hub)
+	// the webhook endpoints the tests start listen on loopback
+	cfg := config.Config{AdminEmails: []string{testAdminEmail}, Webhooks: config.WebhooksConfig{AllowPrivateTargets: true}}
+	registerRoutes(app, cfg, repository.NewGormStore(db), hub, tracker)
+
+	return &testApp{t: t, app: app, db: db, hub: hub, tracker: tracker}
+}
+
+// request sends body as JSON, a nil body sends none, and an empty token
+// leaves out the Authorization header
+func (a *testApp) request(method, path, token string, body interface{}) apiResponse {
+	a.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatalf("encode body: %v", err)
+		}
+		reader = bytes.NewReader(payload)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := a.app.Test(req, -1)
+	if err != nil {
+		a.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		a.t.Fatalf("%s %s: read body: %v", method, path, err)
+	}
+
+	result := apiResponse{Status: resp.StatusCode, Body: raw}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(raw, &result); err != nil {
+			a.t.Fatalf("%s %s: decode body %q: %v", method, path, raw, err)
+		}
+	}
+	return result
+}
+
+// expect fails the test unless the response has the given status
+func (a *testApp) expect(resp apiResponse, status int) apiResponse {
+	a.t.Helper()
+	if resp.Status != status {
+		a.t.Fatalf("expected status %d, got %d: %s", status, resp.Status, resp.Body)
+	}
+	return resp
+}
+
+// decode unmarshals the data field of the response into v
+func (a *testApp) decode(resp apiResponse, v interface{}) {
+	a.t.Helper()
+	if err := json.Unmarshal(resp.Data, v); err != nil {
+		a.t.Fatalf("decode data %s: %v", resp.Data, err)
+	}
+}
+
+func (a *testApp) register(email string) testUser {
+	a.t.Helper()
+
+	resp := a.expect(a.request(http.MethodPost, "/api/register", "", fiber.Map{"email": email, "password": testPassword}), http.StatusOK)
+
+	var data struct {
+		Token string `json:"token"`
+		User  struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		} `json:"user"`
+	}
+	a.decode(resp, &data)
+
+	return testUser{ID: data.User.ID, Email: data.User.Email, Token: data.Token}
+}
+
+// note and task bodies have no json tags, so their keys are the field names
+type noteBody struct {
+	ID          string
+	UserID      string
+	Title       string
+	LastReadAt  *time.Time
+	UnreadTasks int64
+}
+
+type taskBody struct {
+	ID       string
+	NoteID   string
+	Title    string
+	Status   string
+	Priority string
+}
+
+func (a *testApp) createNote(user testUser, title string) noteBody {
+	a.t.Helper()
+
+	var note noteBody
+	a.decode(a.expect(a.request(http.MethodPost, "/api/notes", user.Token, fiber.Map{"title": title}), http.StatusCreated), &note)
+	return note
+}
+
+func (a *testApp) createTask(user testUser, noteID, title string) taskBody {
+	a.t.Helper()
+
+	var task taskBody
+	a.decode(a.expect(a.request(http.MethodPost, "/api/notes/"+noteID+"/tasks", user.Token, fiber.Map{"title": title}), http.StatusCreated), &task)
+	return task
+}
+
+// openStream connects to the event stream over a real connection, since
+// app.Test waits for a response that never ends
+func (a *testApp) openStream(ctx context.Context, user testUser) *http.Response {
+	a.t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		a.t.Fatalf("listen: %v", err)
+	}
+	go a.app.Listener(ln)
+	a.t.Cleanup(func() { ln.Close() })
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+ln.Addr().String()+"/api/events?access_token="+user.Token, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		a.t.Fatalf("open stream: %v", err)
+	}
+	return resp
+}
@@ -0,0 +1,163 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"taskchat/models"
+	"taskchat/repository"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventRegister     = "user.register"
+	EventLoginSuccess = "user.login"
+	EventLoginFailure = "user.login_failed"
+)
+
+// genesisHash is the previous hash of the first entry in the chain
+var genesisHash = strings.Repeat("0", 64)
+
+// Entry is what callers know about an event, the chain fields are filled in by Record
+type Entry struct {
+	Event     string
+	UserID    *uuid.UUID
+	Email     string
+	IP        string
+	UserAgent string
+	Success   bool
+	Metadata  map[string]string
+}
+
+// Record appends an entry to the end of the hash chain
+func Record(store repository.Store, entry Entry) error {
+	return store.Audit().Append(func(last *models.AuditLog) (*models.AuditLog, error) {
+		prevHash, seq := genesisHash, int64(1)
+		if last != nil {
+			prevHash, seq = last.Hash, last.Seq+1
+		}
+
+		var metadata models.JSON
+		if len(entry.Metadata) > 0 {
+			metadata = models.JSON{}
+			for k, v := range entry.Metadata {
+				metadata[k] = v
+			}
+		}
+
+		row := models.AuditLog{
+			Seq:       seq,
+			Event:     entry.Event,
+			UserID:    entry.UserID,
+			Email:     entry.Email,
+			IP:        entry.IP,
+			UserAgent: truncate(entry.UserAgent, 512),
+			Success:   entry.Success,
+			Metadata:  metadata,
+			CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+			PrevHash:  prevHash,
+		}
+
+		hash, err := Hash(&row)
+		if err != nil {
+			return nil, err
+		}
+		row.Hash = hash
+
+		return &row, nil
+	})
+}
+
+// Hash computes the chain hash of a row from its previous hash and contents
+func Hash(row *models.AuditLog) (string, error) {
+	metadata, err := json.Marshal(row.Metadata)
+	if err != nil {
+		return "", err
+	}
+
+	userID := ""
+	if row.UserID != nil {
+		userID = row.UserID.String()
+	}
+
+	payload := strings.Join([]string{
+		row.PrevHash,
+		fmt.Sprint(row.Seq),
+		row.Event,
+		userID,
+		row.Email,
+		row.IP,
+		row.UserAgent,
+		fmt.Sprint(row.Success),
+		string(metadata),
+		row.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}, "|")
+
+	sum := sha256.Sum256([]byte(payload))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyResult reports how much of the chain was checked and where it broke
+type VerifyResult struct {
+	Valid    bool   `json:"valid"`
+	Checked  int64  `json:"checked"`
+	BrokenAt *int64 `json:"broken_at,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Verify walks the whole chain in order and recomputes every hash
+func Verify(store repository.Store) (VerifyResult, error) {
+	result := VerifyResult{Valid: true}
+	prevHash, expectedSeq := genesisHash, int64(1)
+
+	err := store.Audit().Export(repository.AuditFilter{}, func(batch []models.AuditLog) error {
+		for i := range batch {
+			row := &batch[i]
+
+			reason := ""
+			switch {
+			case row.Seq != expectedSeq:
+				reason = fmt.Sprintf("expected seq %d", expectedSeq)
+			case row.PrevHash != prevHash:
+				reason = "previous hash does not match"
+			default:
+				hash, err := Hash(row)
+				if err != nil {
+					return err
+				}
+				if hash != row.Hash {
+					reason = "row hash does not match its contents"
+				}
+			}
+
+			if reason != "" {
+				seq := row.Seq
+				result.Valid, result.BrokenAt, result.Reason = false, &seq, reason
+				return errStopVerify
+			}
+
+			result.Checked++
+			prevHash, expectedSeq = row.Hash, row.Seq+1
+		}
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errStopVerify) {
+		return result, err
+	}
+	return result, nil
+}
+
+var errStopVerify = errors.New("audit chain broken")
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
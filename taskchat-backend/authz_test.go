@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// routes anyone may call without a token
+var publicRoutes = map[string]bool{
+	"GET /api/health":       true,
+	"GET /api/health/live":  true,
+	"GET /api/health/ready": true,
+	"POST /api/register":    true,
+	"POST /api/login":       true,
+	"GET /metrics":          true,
+}
+
+var routeParamRegex = regexp.MustCompile(`:[a-z_]+`)
+
+// TestRoutesRequireAuth walks the registered route table, so a new route
+// that forgets AuthMiddleware fails here without the test being touched
+func TestRoutesRequireAuth(t *testing.T) {
+	a := newTestApp(t)
+
+	checked := 0
+	for _, route := range a.app.GetRoutes(true) {
+		if route.Method == http.MethodHead || !strings.HasPrefix(route.Path, "/api") {
+			continue
+		}
+		if publicRoutes[route.Method+" "+route.Path] {
+			continue
+		}
+
+		path := routeParamRegex.ReplaceAllString(route.Path, "00000000-0000-0000-0000-000000000000")
+		for _, token := range []string{"", "not-a-jwt"} {
+			if resp := a.request(route.Method, path, token, nil); resp.Status != http.StatusUnauthorized {
+				t.Errorf("%s %s with token %q: expected 401, got %d", route.Method, route.Path, token, resp.Status)
+			}
+		}
+		checked++
+	}
+
+	if checked < 35 {
+		t.Fatalf("only %d protected routes found, the route table did not load", checked)
+	}
+}
+
+// TestAuthorizationBoundaries checks that one user can neither see nor
+// change anything belonging to another
+func TestAuthorizationBoundaries(t *testing.T) {
+	a := newTestApp(t)
+	alice := a.register("alice@example.com")
+	mallory := a.register("mallory@example.com")
+
+	note := a.createNote(alice, "Private plans")
+	task := a.createTask(alice, note.ID, "Secret task for @mallory")
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/presence", alice.Token, fiber.Map{"session_id": "alice-tab"}), http.StatusOK)
+
+	trashedNote := a.createNote(alice, "Trashed plans")
+	trashedTask := a.createTask(alice, trashedNote.ID, "Trashed task")
+	a.expect(a.request(http.MethodDelete, "/api/notes/"+trashedNote.ID, alice.Token, nil), http.StatusOK)
+
+	var revisions struct {
+		Revisions []struct{ ID string } `json:"revisions"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/history", alice.Token, nil), http.StatusOK), &revisions)
+	noteRevision := revisions.Revisions[0].ID
+	a.decode(a.expect(a.request(http.MethodGet, "/api/tasks/"+task.ID+"/history", alice.Token, nil), http.StatusOK), &revisions)
+	taskRevision := revisions.Revisions[0].ID
+
+	var hook struct {
+		Webhook struct{ ID string } `json:"webhook"`
+	}
+	a.decode(a.expect(a.request(http.MethodPost, "/api/webhooks", alice.Token, fiber.Map{"url": "http://127.0.0.1:1/hook"}), http.StatusCreated), &hook)
+
+	cases := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodPut, "/api/notes/" + note.ID, fiber.Map{"title": "Taken"}},
+		{http.MethodDelete, "/api/notes/" + note.ID, nil},
+		{http.MethodGet, "/api/notes/" + note.ID + "/history", nil},
+		{http.MethodPost, "/api/notes/" + note.ID + "/history/" + noteRevision + "/revert", nil},
+		{http.MethodGet, "/api/notes/" + note.ID + "/activity", nil},
+		{http.MethodPost, "/api/notes/" + note.ID + "/read", nil},
+		{http.MethodGet, "/api/notes/" + note.ID + "/presence", nil},
+		{http.MethodPost, "/api/notes/" + note.ID + "/presence", fiber.Map{"session_id": "alice-tab"}},
+		{http.MethodDelete, "/api/notes/" + note.ID + "/presence?session_id=alice-tab", nil},
+		{http.MethodGet, "/api/notes/" + note.ID + "/tasks", nil},
+		{http.MethodPost, "/api/notes/" + note.ID + "/tasks", fiber.Map{"title": "Injected"}},
+		{http.MethodPut, "/api/tasks/" + task.ID, fiber.Map{"status": "completed"}},
+		{http.MethodDelete, "/api/tasks/" + task.ID, nil},
+		{http.MethodGet, "/api/tasks/" + task.ID + "/history", nil},
+		{http.MethodPost, "/api/tasks/" + task.ID + "/history/" + taskRevision + "/revert", nil},
+		{http.MethodPost, "/api/trash/notes/" + trashedNote.ID + "/restore", nil},
+		{http.MethodPost, "/api/trash/tasks/" + trashedTask.ID + "/restore", nil},
+		{http.MethodDelete, "/api/trash/notes/" + trashedNote.ID, nil},
+		{http.MethodDelete, "/api/trash/tasks/" + trashedTask.ID, nil},
+		{http.MethodPost, "/api/webhooks", fiber.Map{"url": "http://127.0.0.1:1/steal", "note_id": note.ID}},
+		{http.MethodPut, "/api/webhooks/" + hook.Webhook.ID, fiber.Map{"url": "http://127.0.0.1:1/steal"}},
+		{http.MethodDelete, "/api/webhooks/" + hook.Webhook.ID, nil},
+		{http.MethodPost, "/api/webhooks/" + hook.Webhook.ID + "/test", nil},
+		{http.MethodGet, "/api/webhooks/" + hook.Webhook.ID + "/deliveries", nil},
+	}
+
+	for _, tc := range cases {
+		if resp := a.request(tc.method, tc.path, mallory.Token, tc.body); resp.Status != http.StatusNotFound {
+			t.Errorf("%s %s as another user: expected 404, got %d: %s", tc.method, tc.path, resp.Status, resp.Body)
+		}
+	}
+
+	// a batch touching alice's note fails the same way and changes nothing
+	var batch struct {
+		Results []struct {
+			Status int `json:"status"`
+		} `json:"results"`
+	}
+	ops := []fiber.Map{{"op": "update", "type": "note", "id": note.ID, "data": fiber.Map{"title": "Taken"}}}
+	a.decode(a.expect(a.request(http.MethodPost, "/api/batch", mallory.Token, fiber.Map{"operations": ops}), http.StatusBadRequest), &batch)
+	if batch.Results[0].Status != http.StatusNotFound {
+		t.Errorf("batch on another user's note: expected 404, got %d", batch.Results[0].Status)
+	}
+
+	// the collections mallory can list hold nothing of alice's
+	for _, path := range []string{"/api/notes", "/api/priorities", "/api/activity", "/api/trash", "/api/webhooks"} {
+		resp := a.expect(a.request(http.MethodGet, path, mallory.Token, nil), http.StatusOK)
+		for _, id := range []string{note.ID, task.ID, trashedNote.ID, trashedTask.ID, hook.Webhook.ID} {
+			if strings.Contains(string(resp.Data), id) {
+				t.Errorf("GET %s as another user leaked %s", path, id)
+			}
+		}
+	}
+	a.expect(a.request(http.MethodDelete, "/api/trash", mallory.Token, nil), http.StatusOK)
+
+	// and alice's data came through untouched
+	var notes struct {
+		Notes []noteBody `json:"notes"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes", alice.Token, nil), http.StatusOK), &notes)
+	if len(notes.Notes) != 1 || notes.Notes[0].Title != "Private plans" {
+		t.Fatalf("alice's notes changed: %+v", notes.Notes)
+	}
+
+	var tasks struct {
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/tasks", alice.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 1 || tasks.Tasks[0].Status != "pending" {
+		t.Fatalf("alice's tasks changed: %+v", tasks.Tasks)
+	}
+
+	var trash struct {
+		Notes []noteBody `json:"notes"`
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/trash", alice.Token, nil), http.StatusOK), &trash)
+	if len(trash.Notes) != 1 || trash.Notes[0].ID != trashedNote.ID {
+		t.Fatalf("alice's trash changed: %+v", trash)
+	}
+}
@@ -1,28 +1,519 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
 )
 
+// Config is everything the server can be configured with. Every setting has
+// a default and can be overridden, in increasing order of precedence, by the
+// yaml or toml file, the environment variable in its env tag and the command line
+// flag named after its yaml path, for example -database.max_open_conns
 type Config struct {
-	Port string
+	Server    ServerConfig    `yaml:"server"`
+	Database  DatabaseConfig  `yaml:"database"`
+	JWT       JWTConfig       `yaml:"jwt"`
+	CORS      CORSConfig      `yaml:"cors"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Log       LogConfig       `yaml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Webhooks  WebhooksConfig  `yaml:"webhooks"`
+
+	// days notes and tasks stay in the trash before they are purged
+	TrashRetentionDays int `yaml:"trash_retention_days" env:"TRASH_RETENTION_DAYS" usage:"days notes and tasks stay in the trash"`
+	// users allowed to read the audit log
+	AdminEmails []string `yaml:"admin_emails" env:"ADMIN_EMAILS" usage:"comma separated emails allowed to read the audit log"`
+	// memory for a single instance, postgres to share events between instances
+	EventBus string `yaml:"event_bus" env:"EVENT_BUS" usage:"memory or postgres"`
+}
+
+type ServerConfig struct {
+	Port        string        `yaml:"port" env:"PORT" usage:"port to listen on"`
+	ReadTimeout time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT" usage:"longest time to read a request"`
+	// zero by default, a write deadline would cut off event streams
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT" usage:"longest time to write a response, 0 for none"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" usage:"how long keep-alive connections stay open"`
+	BodyLimit    int           `yaml:"body_limit" env:"SERVER_BODY_LIMIT" usage:"largest request body in bytes"`
+	// how long a signal leaves to drain requests, flush workers and close the database
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"deadline for a graceful shutdown"`
+	// behind a load balancer the client address comes from this header, which
+	// the audit log and rate limiter key on. Use one the balancer overwrites,
+	// like X-Real-IP, the first X-Forwarded-For entry is whatever the client sent
+	ProxyHeader string `yaml:"proxy_header" env:"SERVER_PROXY_HEADER" usage:"header holding the client address set by the load balancer, empty for the connection address"`
+	// the header is only believed from these, anyone else could forge it
+	TrustedProxies []string `yaml:"trusted_proxies" env:"SERVER_TRUSTED_PROXIES" usage:"comma separated addresses or cidr ranges of the load balancers allowed to set proxy_header"`
+}
+
+type DatabaseConfig struct {
+	// postgres, or sqlite for local development and tests
+	Driver string `yaml:"driver" env:"DB_DRIVER" usage:"postgres or sqlite"`
+	// postgres connection string, or a file path or :memory: for sqlite
+	URL             string        `yaml:"url" env:"DATABASE_URL" usage:"postgres connection string or sqlite file" secret:"url"`
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" usage:"most open connections in the pool"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" usage:"most idle connections kept in the pool"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" usage:"how long a connection is reused, 0 for forever"`
+	// failed queries are logged at error, slow ones at warn and the rest at trace
+	SlowQuery time.Duration `yaml:"slow_query" env:"DB_SLOW_QUERY" usage:"queries slower than this are logged as slow, 0 for never"`
+}
+
+type JWTConfig struct {
+	Secret string        `yaml:"secret" env:"JWT_SECRET" usage:"key tokens are signed with" secret:"true"`
+	TTL    time.Duration `yaml:"ttl" env:"JWT_TTL" usage:"how long an issued token is valid"`
+}
+
+type CORSConfig struct {
+	// no origins leaves CORS off, the api is then only usable same origin
+	AllowOrigins []string `yaml:"allow_origins" env:"CORS_ALLOW_ORIGINS" usage:"comma separated origins allowed to call the api, * for any"`
+}
+
+type RateLimitConfig struct {
+	// zero turns rate limiting off
+	Requests int           `yaml:"requests" env:"RATE_LIMIT_REQUESTS" usage:"requests a client may make per window, 0 for no limit"`
+	Window   time.Duration `yaml:"window" env:"RATE_LIMIT_WINDOW" usage:"length of the rate limit window"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" usage:"trace, debug, info, warn or error"`
+	Format string `yaml:"format" env:"LOG_FORMAT" usage:"json or console"`
+	// client errors are logged at warn and server errors at error whatever this is,
+	// request headers are added at debug and json bodies at trace, both redacted
+	RequestLevel string `yaml:"request_level" env:"LOG_REQUEST_LEVEL" usage:"level successful requests are logged at"`
+}
+
+type TracingConfig struct {
+	// none leaves tracing off, stdout prints spans for local debugging
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER" usage:"none, stdout or otlp"`
+	// OTLP over HTTP, /v1/traces is added when the url has no path
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT" usage:"url of the otlp collector, like http://localhost:4318"`
+	ServiceName string  `yaml:"service_name" env:"TRACING_SERVICE_NAME" usage:"service name spans are reported under"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" usage:"share of new traces recorded, from 0 to 1"`
+}
+
+type WebhooksConfig struct {
+	// off by default so users cannot point webhooks at the internal network
+	// or the cloud metadata service, turn on to test against a local server
+	AllowPrivateTargets bool `yaml:"allow_private_targets" env:"WEBHOOKS_ALLOW_PRIVATE_TARGETS" usage:"let webhooks reach loopback, private and link-local addresses"`
+}
+
+// Default is the configuration before any file, variable or flag is applied
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Port:        "8081",
+			ReadTimeout: 10 * time.Second,
+			IdleTimeout: 60 * time.Second,
+			BodyLimit:   4 * 1024 * 1024,
+
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Driver:          "postgres",
+			MaxOpenConns:    50,
+			MaxIdleConns:    50,
+			ConnMaxLifetime: time.Hour,
+			SlowQuery:       200 * time.Millisecond,
+		},
+		JWT: JWTConfig{
+			TTL: 24 * time.Hour,
+		},
+		RateLimit: RateLimitConfig{
+			Window: time.Minute,
+		},
+		Log: LogConfig{
+			Level:        "info",
+			Format:       "json",
+			RequestLevel: "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "http://localhost:4318",
+			ServiceName: "taskchat",
+			SampleRatio: 1,
+		},
+		TrashRetentionDays: 30,
+		EventBus:           "memory",
+	}
+}
+
+// TrashRetention is how long notes and tasks stay in the trash
+func (c Config) TrashRetention() time.Duration {
+	return time.Duration(c.TrashRetentionDays) * 24 * time.Hour
+}
+
+// Load builds the configuration from the defaults, the yaml or toml file named by
+// -config or CONFIG_FILE, the environment, including a .env file when there
+// is one, and the flags in args. It returns the arguments left after the
+// flags, which name the command to run
+func Load(args []string) (Config, []string, error) {
+	cfg := Default()
+
+	// a .env file is a convenience for development, production sets real variables
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return cfg, nil, fmt.Errorf("error loading .env file: %v", err)
+	}
+
+	flags := flag.NewFlagSet("taskchat", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "yaml or toml file to load settings from")
+
+	// flags are collected first and applied last so they win over the file and environment
+	setFlags := map[string]string{}
+	for _, setting := range settings(&cfg) {
+		name := setting.path
+		flags.Func(name, setting.usage, func(raw string) error {
+			setFlags[name] = raw
+			return nil
+		})
+	}
+
+	// every problem is collected, so one run lists all there is to fix
+	var problems []string
+	if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return cfg, nil, err
+	} else if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if *configFile != "" {
+		if err := loadFile(&cfg, *configFile); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	for _, setting := range settings(&cfg) {
+		// an empty variable counts as unset, like a blank line in .env
+		if raw := os.Getenv(setting.env); setting.env != "" && raw != "" {
+			if err := setValue(setting.value, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", setting.env, err))
+			}
+		}
+		if raw, ok := setFlags[setting.path]; ok {
+			if err := setValue(setting.value, raw); err != nil {
+				problems = append(problems, fmt.Sprintf("-%s: %v", setting.path, err))
+			}
+		}
+	}
+
+	// sqlite needs no server, so it gets a file next to the binary by default
+	if cfg.Database.URL == "" && cfg.Database.Driver == "sqlite" {
+		cfg.Database.URL = "taskchat.db"
+	}
+	for i, email := range cfg.AdminEmails {
+		cfg.AdminEmails[i] = strings.ToLower(email)
+	}
+
+	// the rest of the settings are checked too when some could not be read,
+	// otherwise the caller validates them when the command needs it
+	if len(problems) > 0 {
+		var invalid *ValidationError
+		if errors.As(cfg.Validate(), &invalid) {
+			problems = append(problems, invalid.Problems...)
+		}
+		return cfg, nil, &ValidationError{Problems: problems}
+	}
+
+	return cfg, flags.Args(), nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %v", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+	case ".toml":
+		// toml goes through yaml so both formats share the yaml tags and
+		// the check for misspelt keys
+		var values map[string]interface{}
+		if err := toml.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("error reading config file %s: %v", path, err)
+		}
+		if data, err = yaml.Marshal(values); err != nil {
+			return fmt.Errorf("error reading config file %s: %v", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q, use .yaml, .yml or .toml", path, ext)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// a misspelt key would otherwise be ignored without a word
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// ValidationError lists every problem found in the configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+var originRegex = regexp.MustCompile(`^https?://[^/\s]+$`)
+
+// Validate checks every setting and reports all the problems at once
+func (c Config) Validate() error {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.Server.Port); err != nil || port < 1 || port > 65535 {
+		problem("server.port must be a number between 1 and 65535, got %q", c.Server.Port)
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 {
+		problem("server timeouts cannot be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		problem("server.shutdown_timeout must be positive")
+	}
+	if c.Server.BodyLimit < 1 {
+		problem("server.body_limit must be at least 1 byte")
+	}
+	if c.Server.ProxyHeader != "" && len(c.Server.TrustedProxies) == 0 {
+		problem("server.trusted_proxies is required when server.proxy_header is set")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problem("server.trusted_proxies entry %q is not an address or cidr range", proxy)
+		}
+	}
+
+	switch c.Database.Driver {
+	case "postgres", "sqlite":
+	default:
+		problem("database.driver must be postgres or sqlite, got %q", c.Database.Driver)
+	}
+	if c.Database.URL == "" {
+		problem("database.url is required, set DATABASE_URL")
+	}
+	if c.Database.MaxOpenConns < 1 {
+		problem("database.max_open_conns must be at least 1")
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		problem("database.max_idle_conns must be between 0 and max_open_conns")
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		problem("database.conn_max_lifetime cannot be negative")
+	}
+	if c.Database.SlowQuery < 0 {
+		problem("database.slow_query cannot be negative")
+	}
+
+	if c.JWT.Secret == "" {
+		problem("jwt.secret is required, set JWT_SECRET")
+	}
+	if c.JWT.TTL <= 0 {
+		problem("jwt.ttl must be positive")
+	}
+
+	for _, origin := range c.CORS.AllowOrigins {
+		if origin != "*" && !originRegex.MatchString(origin) {
+			problem("cors.allow_origins entry %q must be * or an origin like https://app.example.com", origin)
+		}
+	}
+
+	if c.RateLimit.Requests < 0 {
+		problem("rate_limit.requests cannot be negative")
+	}
+	if c.RateLimit.Requests > 0 && c.RateLimit.Window <= 0 {
+		problem("rate_limit.window must be positive when rate limiting is on")
+	}
+
+	if _, err := zerolog.ParseLevel(c.Log.Level); err != nil || c.Log.Level == "" {
+		problem("log.level must be trace, debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if _, err := zerolog.ParseLevel(c.Log.RequestLevel); err != nil || c.Log.RequestLevel == "" {
+		problem("log.request_level must be trace, debug, info, warn or error, got %q", c.Log.RequestLevel)
+	}
+	if c.Log.Format != "json" && c.Log.Format != "console" {
+		problem("log.format must be json or console, got %q", c.Log.Format)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problem("tracing.endpoint must be an http or https url, got %q", c.Tracing.Endpoint)
+		}
+	default:
+		problem("tracing.exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		problem("tracing.sample_ratio must be between 0 and 1")
+	}
+	if c.Tracing.ServiceName == "" {
+		problem("tracing.service_name is required")
+	}
+
+	if c.TrashRetentionDays < 1 {
+		problem("trash_retention_days must be at least 1")
+	}
+	for _, email := range c.AdminEmails {
+		if !strings.Contains(email, "@") {
+			problem("admin_emails entry %q is not an email address", email)
+		}
+	}
+	switch c.EventBus {
+	case "memory":
+	case "postgres":
+		if c.Database.Driver != "postgres" {
+			problem("event_bus postgres needs database.driver postgres")
+		}
+	default:
+		problem("event_bus must be memory or postgres, got %q", c.EventBus)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
 }
 
-func LoadConfig() Config {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
+// Redacted returns a copy that is safe to print, secrets are masked and
+// only the password is taken out of the database url
+func (c Config) Redacted() Config {
+	redacted := c
+	redacted.AdminEmails = append([]string(nil), c.AdminEmails...)
+	redacted.CORS.AllowOrigins = append([]string(nil), c.CORS.AllowOrigins...)
+	redacted.Server.TrustedProxies = append([]string(nil), c.Server.TrustedProxies...)
+
+	for _, setting := range settings(&redacted) {
+		if setting.value.Kind() != reflect.String || setting.value.String() == "" {
+			continue
+		}
+		switch setting.secret {
+		case "true":
+			setting.value.SetString("REDACTED")
+		case "url":
+			setting.value.SetString(redactURL(setting.value.String()))
+		}
+	}
+	return redacted
+}
+
+var dsnPasswordRegex = regexp.MustCompile(`(?i)(password=)(\S+)`)
+
+// redactURL masks the password in a postgres url or key=value connection string
+func redactURL(raw string) string {
+	if parsed, err := url.Parse(raw); err == nil && parsed.User != nil {
+		if _, ok := parsed.User.Password(); ok {
+			parsed.User = url.UserPassword(parsed.User.Username(), "REDACTED")
+			return parsed.String()
+		}
 	}
-	return Config{Port: port}
+	return dsnPasswordRegex.ReplaceAllString(raw, "${1}REDACTED")
 }
 
-func ValidateEnvVars() error {
-	vars := []string{"DATABASE_URL", "JWT_SECRET", "CORS_ALLOW_ORIGINS"}
-	for _, v := range vars {
-		if os.Getenv(v) == "" {
-			return fmt.Errorf("%v is is not there", v)
+// YAML renders the configuration in the same shape the config file takes
+func (c Config) YAML() (string, error) {
+	out, err := yaml.Marshal(c)
+	return string(out), err
+}
+
+// setting is one leaf of Config with the names it can be set by
+type setting struct {
+	path   string
+	env    string
+	usage  string
+	secret string
+	value  reflect.Value
+}
+
+// settings walks cfg and returns every leaf setting, named by its yaml path
+func settings(cfg *Config) []setting {
+	var found []setting
+
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			path := prefix + strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), path+".")
+				continue
+			}
+			found = append(found, setting{
+				path:   path,
+				env:    field.Tag.Get("env"),
+				usage:  field.Tag.Get("usage"),
+				secret: field.Tag.Get("secret"),
+				value:  v.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+
+	return found
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setValue parses raw into the setting, lists are comma separated
+func setValue(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 1h", raw)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
 		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
 	}
 	return nil
 }
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	yamlFile := write("taskchat.yaml", "server:\n  port: \"9000\"\n  read_timeout: 5s\nadmin_emails: [a@example.com]\n")
+	tomlFile := write("taskchat.toml", "admin_emails = [\"a@example.com\"]\n\n[server]\nport = \"9000\"\nread_timeout = \"5s\"\n")
+	for _, path := range []string{yamlFile, tomlFile} {
+		cfg := Default()
+		if err := loadFile(&cfg, path); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if cfg.Server.Port != "9000" || cfg.Server.ReadTimeout != 5*time.Second || len(cfg.AdminEmails) != 1 {
+			t.Fatalf("%s loaded %+v", path, cfg)
+		}
+		// settings the file leaves out keep their defaults
+		if cfg.Database.Driver != Default().Database.Driver {
+			t.Fatalf("%s reset the database driver to %q", path, cfg.Database.Driver)
+		}
+	}
+
+	// misspelt keys are rejected in both formats
+	for _, path := range []string{
+		write("typo.yaml", "server:\n  prot: \"9000\"\n"),
+		write("typo.toml", "[server]\nprot = \"9000\"\n"),
+	} {
+		cfg := Default()
+		if err := loadFile(&cfg, path); err == nil {
+			t.Fatalf("%s: misspelt key accepted", path)
+		}
+	}
+
+	cfg := Default()
+	if err := loadFile(&cfg, write("taskchat.json", `{"server":{"port":"9000"}}`)); err == nil || !strings.Contains(err.Error(), "unsupported extension") {
+		t.Fatalf("json file returned %v", err)
+	}
+}
+
+func TestValidateTrustedProxies(t *testing.T) {
+	cfg := Default()
+	cfg.Database.URL, cfg.JWT.Secret = "postgres://localhost/taskchat", "secret"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults rejected: %v", err)
+	}
+
+	// a proxy header believed from anyone would let clients pick their address
+	cfg.Server.ProxyHeader = "X-Real-IP"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.trusted_proxies is required") {
+		t.Fatalf("proxy header without trusted proxies returned %v", err)
+	}
+
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.10", "lb.internal"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), `"lb.internal"`) || strings.Contains(err.Error(), "10.0.0.0/8") {
+		t.Fatalf("trusted proxies returned %v", err)
+	}
+}
+
+func TestLoadListsEveryProblem(t *testing.T) {
+	t.Setenv("DATABASE_URL", "postgres://localhost/taskchat")
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("SERVER_READ_TIMEOUT", "soon")
+	t.Setenv("EVENT_BUS", "kafka")
+
+	// a value that cannot be read does not hide the others or what Validate finds
+	_, _, err := Load([]string{"-database.max_open_conns=many"})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	for _, want := range []string{"SERVER_READ_TIMEOUT", "-database.max_open_conns", "event_bus"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%s missing from %v", want, err)
+		}
+	}
+	if len(invalid.Problems) != 3 {
+		t.Fatalf("expected 3 problems, got %q", invalid.Problems)
+	}
+
+	// without them the caller validates when the command needs it
+	t.Setenv("SERVER_READ_TIMEOUT", "5s")
+	if _, _, err := Load(nil); err != nil {
+		t.Fatalf("settings that parse returned %v", err)
+	}
+}
@@ -2,54 +2,82 @@ package database
 
 import (
 	"fmt"
-	"time"
-
-	"os"
-	"taskchat/models"
+	"taskchat/config"
 
+	"github.com/glebarez/sqlite"
 	"github.com/rs/zerolog/log"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-var DB *gorm.DB
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// InitDB connects with the configured driver and applies any pending
+// migrations. For sqlite the url is a file path, or :memory: for a database
+// that lives as long as the process
+func InitDB(cfg config.DatabaseConfig) (*gorm.DB, error) {
+
+	db, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := MigrateUp(db)
+	if err != nil {
+		return nil, fmt.Errorf("error migrating database %v", err)
+	}
+
+	log.Info().Int("applied", applied).Msg("Database migrated")
+
+	return db, nil
+}
 
-func InitDB() error {
+// Open connects with the configured driver without touching the schema
+func Open(cfg config.DatabaseConfig) (*gorm.DB, error) {
 
-	dsn := os.Getenv("DATABASE_URL")
+	driver, dsn := cfg.Driver, cfg.URL
 	if dsn == "" {
-		return fmt.Errorf("error connecting database from .env")
+		return nil, fmt.Errorf("error connecting database, no DATABASE_URL set")
+	}
+
+	var dialector gorm.Dialector
+	switch driver {
+	case DriverPostgres:
+		dialector = postgres.Open(dsn)
+	case DriverSQLite:
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
 	}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+	db, err := gorm.Open(dialector, &gorm.Config{
+		Logger: newQueryLogger(cfg.SlowQuery),
 	})
 	if err != nil {
-		return fmt.Errorf("error connecting database %v", err)
+		return nil, fmt.Errorf("error connecting database %v", err)
 	}
 
 	sqlDB, err := db.DB()
 	if err != nil {
-		return fmt.Errorf("cannot get the datbase instance %v", err)
+		return nil, fmt.Errorf("cannot get the datbase instance %v", err)
 	}
 
-	sqlDB.SetMaxOpenConns(50)
-	sqlDB.SetMaxIdleConns(50)
-	sqlDB.SetConnMaxLifetime(time.Hour)
-
-	fmt.Println(db, "*******************")
-	fmt.Println(DB, "*******************")
-
-	DB = db
-	log.Info().Msg("Database connected")
-
-	err = db.AutoMigrate(&models.User{}, &models.Note{}, &models.Task{})
-	if err != nil {
-		return fmt.Errorf("error migrating database %v", err)
+	if driver == DriverSQLite {
+		// sqlite has a single writer, and an in-memory database only exists
+		// on the connection that created it, so keep exactly one open forever
+		sqlDB.SetMaxOpenConns(1)
+		sqlDB.SetMaxIdleConns(1)
+		sqlDB.SetConnMaxLifetime(0)
+	} else {
+		sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+		sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+		sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	}
 
-	log.Info().Msg("migration connected")
+	log.Info().Str("driver", driver).Msg("Database connected")
 
-	return nil
+	return db, nil
 }
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// queryLogger writes gorm's logs through zerolog, with the request id and
+// trace of the context the query ran with. Statements are logged with their
+// placeholders, the values can be passwords and personal data
+type queryLogger struct {
+	slow  time.Duration
+	level logger.LogLevel
+}
+
+func newQueryLogger(slow time.Duration) logger.Interface {
+	return &queryLogger{slow: slow, level: logger.Info}
+}
+
+func (l *queryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	next := *l
+	next.level = level
+	return &next
+}
+
+func (l *queryLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Info {
+		log.Info().Ctx(ctx).Msgf(msg, args...)
+	}
+}
+
+func (l *queryLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Warn {
+		log.Warn().Ctx(ctx).Msgf(msg, args...)
+	}
+}
+
+func (l *queryLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= logger.Error {
+		log.Error().Ctx(ctx).Msgf(msg, args...)
+	}
+}
+
+// ParamsFilter keeps the values out of the statements passed to Trace
+func (l *queryLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
+
+func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+
+	switch {
+	// a missing record is an answer, the handlers turn it into a 404
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		sql, rows := fc()
+		log.Error().Ctx(ctx).Err(err).Dur("elapsed", elapsed).Str("sql", sql).Int64("rows", rows).Msg("Query failed")
+
+	case l.slow > 0 && elapsed > l.slow && l.level >= logger.Warn:
+		sql, rows := fc()
+		log.Warn().Ctx(ctx).Dur("elapsed", elapsed).Str("sql", sql).Int64("rows", rows).Msg("Slow query")
+
+	case l.level >= logger.Info:
+		// building the statement is skipped unless someone reads it
+		if event := log.Trace(); event.Enabled() {
+			sql, rows := fc()
+			event.Ctx(ctx).Dur("elapsed", elapsed).Str("sql", sql).Int64("rows", rows).Msg("Query")
+		}
+	}
+}
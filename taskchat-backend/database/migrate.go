@@ -0,0 +1,319 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// migrations/<driver>/<version>_<name>.up.sql and .down.sql, every change
+// is written once per driver under the same version
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// any number that is not a real table id, shared by every instance
+const migrationLockKey = 7_402_118_530
+
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// sqlite has no ADD COLUMN IF NOT EXISTS, see sqliteAddColumns
+var addColumnRegex = regexp.MustCompile("(?m)^ALTER TABLE `(\\w+)` ADD COLUMN IF NOT EXISTS `(\\w+)`")
+
+// Migration is one versioned schema change
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is a migration and when it was applied, nil when pending
+type MigrationState struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the embedded migrations for the driver in version order
+func loadMigrations(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations/"+driver)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %q", driver)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(migrationFiles, "migrations/"+driver+"/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// MigrateUp applies every pending migration and returns how many ran
+func MigrateUp(db *gorm.DB) (int, error) {
+	applied := 0
+	err := withMigrationLock(db, func(conn *gorm.DB, states []MigrationState) error {
+		for _, state := range states {
+			if state.AppliedAt != nil {
+				continue
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				up := state.Up
+				if conn.Dialector.Name() == DriverSQLite {
+					var err error
+					if up, err = sqliteAddColumns(tx, up); err != nil {
+						return err
+					}
+				}
+				if err := tx.Exec(up).Error; err != nil {
+					return err
+				}
+				return tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)", state.Version, state.Name, time.Now()).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s failed: %v", state.Version, state.Name, err)
+			}
+
+			log.Info().Int64("version", state.Version).Str("name", state.Name).Msg("Migration applied")
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// sqliteAddColumns rewrites the ADD COLUMN IF NOT EXISTS statements of a
+// migration into ones sqlite understands, commenting out those whose column
+// is already there
+func sqliteAddColumns(tx *gorm.DB, sql string) (string, error) {
+	var err error
+	sql = addColumnRegex.ReplaceAllStringFunc(sql, func(statement string) string {
+		match := addColumnRegex.FindStringSubmatch(statement)
+
+		var count int64
+		if scanErr := tx.Raw("SELECT count(*) FROM pragma_table_info(?) WHERE name = ?", match[1], match[2]).Scan(&count).Error; scanErr != nil {
+			err = scanErr
+			return statement
+		}
+		if count > 0 {
+			return "-- " + statement
+		}
+		return fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s`", match[1], match[2])
+	})
+	return sql, err
+}
+
+// MigrateDown reverts the most recently applied migrations, at most steps of them
+func MigrateDown(db *gorm.DB, steps int) (int, error) {
+	reverted := 0
+	err := withMigrationLock(db, func(conn *gorm.DB, states []MigrationState) error {
+		for i := len(states) - 1; i >= 0 && reverted < steps; i-- {
+			state := states[i]
+			if state.AppliedAt == nil {
+				continue
+			}
+
+			err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := tx.Exec(state.Down).Error; err != nil {
+					return err
+				}
+				return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", state.Version).Error
+			})
+			if err != nil {
+				return fmt.Errorf("reverting migration %d_%s failed: %v", state.Version, state.Name, err)
+			}
+
+			log.Info().Int64("version", state.Version).Str("name", state.Name).Msg("Migration reverted")
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// MigrationStatus lists every known migration and whether it has been applied
+func MigrationStatus(db *gorm.DB) ([]MigrationState, error) {
+	var states []MigrationState
+	err := withMigrationLock(db, func(conn *gorm.DB, current []MigrationState) error {
+		states = current
+		return nil
+	})
+	return states, err
+}
+
+// PendingMigrations returns the versions not applied yet. It reads
+// schema_migrations without taking the lock, so it is cheap enough for
+// readiness checks and does not wait on another instance migrating
+func PendingMigrations(ctx context.Context, db *gorm.DB) ([]int64, error) {
+	migrations, err := loadMigrations(db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []int64
+	if err := db.WithContext(ctx).Raw("SELECT version FROM schema_migrations").Scan(&applied).Error; err != nil {
+		return nil, err
+	}
+
+	pending := []int64{}
+	for _, m := range migrations {
+		if !slices.Contains(applied, m.Version) {
+			pending = append(pending, m.Version)
+		}
+	}
+	return pending, nil
+}
+
+// withMigrationLock runs fn on a single connection while holding the
+// migration lock, so only one instance changes the schema at a time
+func withMigrationLock(db *gorm.DB, fn func(conn *gorm.DB, states []MigrationState) error) error {
+	driver := db.Dialector.Name()
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return err
+	}
+
+	// a session level lock belongs to one connection, so everything runs on it
+	return db.Connection(func(conn *gorm.DB) error {
+		if driver == DriverPostgres {
+			if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockKey).Error; err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockKey)
+		}
+
+		err := conn.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(255) NOT NULL, applied_at TIMESTAMP NOT NULL)").Error
+		if err != nil {
+			return err
+		}
+
+		var rows []struct {
+			Version   int64
+			AppliedAt time.Time
+		}
+		if err := conn.Raw("SELECT version, applied_at FROM schema_migrations").Scan(&rows).Error; err != nil {
+			return err
+		}
+
+		applied := make(map[int64]time.Time, len(rows))
+		for _, row := range rows {
+			applied[row.Version] = row.AppliedAt
+		}
+
+		states := make([]MigrationState, len(migrations))
+		for i, m := range migrations {
+			states[i] = MigrationState{Migration: m}
+			if at, ok := applied[m.Version]; ok {
+				states[i].AppliedAt = &at
+			}
+		}
+
+		return fn(conn, states)
+	})
+}
+
+// CreateMigration writes empty up and down files for the next version under
+// dir for every driver and returns their paths
+func CreateMigration(dir, name string) ([]string, error) {
+	name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), " ", "_"))
+	if !regexp.MustCompile(`^[a-z0-9_]+$`).MatchString(name) {
+		return nil, fmt.Errorf("migration name may only contain letters, digits and underscores")
+	}
+
+	drivers := []string{DriverPostgres, DriverSQLite}
+
+	// the next version is one past the highest used by any driver
+	var next int64 = 1
+	for _, driver := range drivers {
+		migrations, err := loadMigrationsFrom(os.DirFS(filepath.Join(dir, driver)))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range migrations {
+			if m >= next {
+				next = m + 1
+			}
+		}
+	}
+
+	var paths []string
+	for _, driver := range drivers {
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(dir, driver, fmt.Sprintf("%04d_%s.%s.sql", next, name, direction))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(path, []byte(fmt.Sprintf("-- %s %s for %s\n", name, direction, driver)), 0o644); err != nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// loadMigrationsFrom returns the versions found in a migrations directory on disk
+func loadMigrationsFrom(dir fs.FS) ([]int64, error) {
+	entries, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []int64
+	for _, entry := range entries {
+		if match := migrationFileRegex.FindStringSubmatch(entry.Name()); match != nil {
+			version, _ := strconv.ParseInt(match[1], 10, 64)
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
@@ -0,0 +1,21 @@
+//go:build postgres
+
+package database
+
+import (
+	"os"
+	"taskchat/config"
+	"testing"
+)
+
+// TestMigrationsPostgres needs a throwaway database in TEST_DATABASE_URL,
+// every table in it is dropped, e.g.
+//
+//	TEST_DATABASE_URL=postgres://localhost/taskchat_test go test -tags postgres ./database
+func TestMigrationsPostgres(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	testMigrations(t, config.DatabaseConfig{Driver: DriverPostgres, URL: url})
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"taskchat/config"
+	"taskchat/models"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// every table the migrations have to create for the models
+var migratedModels = []interface{}{
+	&models.User{}, &models.Note{}, &models.Task{},
+	&models.Revision{}, &models.Activity{}, &models.AuditLog{},
+	&models.Webhook{}, &models.WebhookDelivery{}, &models.WebhookAttempt{},
+	&models.OutboxEvent{}, &models.NoteRead{},
+}
+
+func TestMigrationsSQLite(t *testing.T) {
+	testMigrations(t, config.DatabaseConfig{Driver: DriverSQLite, URL: ":memory:"})
+}
+
+// testMigrations runs every migration up, down to nothing and up again on a
+// fresh database, checking the schema has a column for every model field
+func testMigrations(t *testing.T, cfg config.DatabaseConfig) {
+	db, err := Open(cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	migrations, err := loadMigrations(cfg.Driver)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+
+	for round := 0; round < 2; round++ {
+		applied, err := MigrateUp(db)
+		if err != nil {
+			t.Fatalf("migrate up: %v", err)
+		}
+		if applied != len(migrations) {
+			t.Fatalf("applied %d of %d migrations", applied, len(migrations))
+		}
+		checkSchema(t, db)
+
+		reverted, err := MigrateDown(db, len(migrations))
+		if err != nil {
+			t.Fatalf("migrate down: %v", err)
+		}
+		if reverted != len(migrations) {
+			t.Fatalf("reverted %d of %d migrations", reverted, len(migrations))
+		}
+		for _, model := range migratedModels {
+			if db.Migrator().HasTable(model) {
+				t.Fatalf("%T still has a table after reverting every migration", model)
+			}
+		}
+	}
+}
+
+func checkSchema(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	for _, model := range migratedModels {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			t.Fatalf("parse %T: %v", model, err)
+		}
+		if !db.Migrator().HasTable(model) {
+			t.Fatalf("%T has no table after migrating", model)
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !db.Migrator().HasColumn(model, field.DBName) {
+				t.Errorf("%s has no column %s", stmt.Schema.Table, field.DBName)
+			}
+		}
+	}
+}
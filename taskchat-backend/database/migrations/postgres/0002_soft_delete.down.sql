@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS "idx_tasks_deleted_at";
+ALTER TABLE "tasks" DROP COLUMN "deleted_at";
+DROP INDEX IF EXISTS "idx_notes_deleted_at";
+ALTER TABLE "notes" DROP COLUMN "deleted_at";
@@ -0,0 +1,8 @@
+-- Trash for notes and tasks. Databases AutoMigrate created once trash existed
+-- already have the columns, the statements leave those as they are.
+
+ALTER TABLE "notes" ADD COLUMN IF NOT EXISTS "deleted_at" timestamptz;
+CREATE INDEX IF NOT EXISTS "idx_notes_deleted_at" ON "notes"("deleted_at");
+
+ALTER TABLE "tasks" ADD COLUMN IF NOT EXISTS "deleted_at" timestamptz;
+CREATE INDEX IF NOT EXISTS "idx_tasks_deleted_at" ON "tasks"("deleted_at");
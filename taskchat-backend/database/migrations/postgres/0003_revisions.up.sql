@@ -0,0 +1,6 @@
+-- Revision history of notes and tasks.
+
+CREATE TABLE IF NOT EXISTS "revisions" ("id" uuid,"entity_type" varchar(20) NOT NULL,"entity_id" uuid NOT NULL,"user_id" uuid NOT NULL,"action" varchar(20) NOT NULL,"changes" jsonb,"snapshot" jsonb NOT NULL,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_revisions_created_at" ON "revisions"("created_at");
+CREATE INDEX IF NOT EXISTS "idx_revisions_user_id" ON "revisions"("user_id");
+CREATE INDEX IF NOT EXISTS "idx_revisions_entity" ON "revisions"("entity_type","entity_id");
@@ -0,0 +1,5 @@
+-- Activity feed entries for notes and users.
+
+CREATE TABLE IF NOT EXISTS "activities" ("id" uuid,"user_id" uuid NOT NULL,"note_id" uuid NOT NULL,"task_id" uuid,"type" varchar(50) NOT NULL,"data" jsonb,"created_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_activities_note_created" ON "activities"("note_id","created_at");
+CREATE INDEX IF NOT EXISTS "idx_activities_user_id" ON "activities"("user_id");
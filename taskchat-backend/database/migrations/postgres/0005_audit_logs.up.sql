@@ -0,0 +1,6 @@
+-- Hash chained audit log of security relevant events.
+
+CREATE TABLE IF NOT EXISTS "audit_logs" ("seq" bigint,"event" varchar(50) NOT NULL,"user_id" uuid,"email" varchar(255),"ip" varchar(64),"user_agent" varchar(512),"success" boolean NOT NULL,"metadata" jsonb,"created_at" timestamptz NOT NULL,"prev_hash" varchar(64) NOT NULL,"hash" varchar(64) NOT NULL,PRIMARY KEY ("seq"));
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_created_at" ON "audit_logs"("created_at");
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_user_id" ON "audit_logs"("user_id");
+CREATE INDEX IF NOT EXISTS "idx_audit_logs_event" ON "audit_logs"("event");
@@ -0,0 +1,5 @@
+-- Transactional outbox, events written with the change that caused them.
+
+CREATE TABLE IF NOT EXISTS "outbox_events" ("id" uuid,"type" varchar(50) NOT NULL,"user_id" uuid NOT NULL,"note_id" uuid NOT NULL,"payload" jsonb NOT NULL,"created_at" timestamptz,"published_at" timestamptz,PRIMARY KEY ("id"));
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_published_at" ON "outbox_events"("published_at");
+CREATE INDEX IF NOT EXISTS "idx_outbox_events_created_at" ON "outbox_events"("created_at");
@@ -0,0 +1,3 @@
+-- How far each user has read each note, for unread counts.
+
+CREATE TABLE IF NOT EXISTS "note_reads" ("user_id" uuid,"note_id" uuid,"last_read_at" timestamptz NOT NULL,PRIMARY KEY ("user_id","note_id"));
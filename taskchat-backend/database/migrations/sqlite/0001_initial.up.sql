@@ -0,0 +1,12 @@
+-- Baseline schema, exactly what AutoMigrate created before migrations
+-- existed. IF NOT EXISTS lets it run against such a database unchanged,
+-- every later change to these tables is a migration of its own.
+
+CREATE TABLE IF NOT EXISTS `users` (`id` uuid,`email` varchar(255) NOT NULL,`password_hash` varchar(255) NOT NULL,`created_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `uni_users_email` UNIQUE (`email`));
+
+CREATE TABLE IF NOT EXISTS `notes` (`id` uuid,`user_id` uuid NOT NULL,`title` varchar(100) NOT NULL,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE INDEX IF NOT EXISTS `idx_notes_user_id` ON `notes`(`user_id`);
+
+CREATE TABLE IF NOT EXISTS `tasks` (`id` uuid,`note_id` uuid NOT NULL,`user_id` uuid NOT NULL,`title` varchar(255) NOT NULL,`status` varchar(100) NOT NULL DEFAULT "pending",`priority` varchar(100) NOT NULL DEFAULT " ",`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE INDEX IF NOT EXISTS `idx_tasks_user_id` ON `tasks`(`user_id`);
+CREATE INDEX IF NOT EXISTS `idx_tasks_note_id` ON `tasks`(`note_id`);
@@ -0,0 +1,13 @@
+-- Outgoing webhooks with their queued deliveries and every attempt made.
+
+CREATE TABLE IF NOT EXISTS `webhooks` (`id` uuid,`user_id` uuid NOT NULL,`note_id` uuid,`url` varchar(2048) NOT NULL,`secret` varchar(128) NOT NULL,`events` varchar(1024) NOT NULL DEFAULT "*",`active` numeric NOT NULL DEFAULT true,`failure_count` integer NOT NULL DEFAULT 0,`disabled_at` datetime,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE INDEX IF NOT EXISTS `idx_webhooks_note_id` ON `webhooks`(`note_id`);
+CREATE INDEX IF NOT EXISTS `idx_webhooks_user_id` ON `webhooks`(`user_id`);
+
+CREATE TABLE IF NOT EXISTS `webhook_deliveries` (`id` uuid,`webhook_id` uuid NOT NULL,`event_id` uuid,`event_type` varchar(50) NOT NULL,`payload` jsonb NOT NULL,`status` varchar(20) NOT NULL DEFAULT "pending",`attempts` integer NOT NULL DEFAULT 0,`next_attempt_at` datetime NOT NULL,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`));
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_due` ON `webhook_deliveries`(`status`,`next_attempt_at`);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_webhook_deliveries_event` ON `webhook_deliveries`(`webhook_id`,`event_id`);
+CREATE INDEX IF NOT EXISTS `idx_webhook_deliveries_webhook_id` ON `webhook_deliveries`(`webhook_id`);
+
+CREATE TABLE IF NOT EXISTS `webhook_attempts` (`id` uuid,`delivery_id` uuid NOT NULL,`status_code` integer,`error` text,`duration_ms` integer NOT NULL,`created_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_webhook_deliveries_delivery_attempts` FOREIGN KEY (`delivery_id`) REFERENCES `webhook_deliveries`(`id`));
+CREATE INDEX IF NOT EXISTS `idx_webhook_attempts_delivery_id` ON `webhook_attempts`(`delivery_id`);
@@ -0,0 +1,239 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Event is a change to a note or task pushed to connected clients. ID is the
+// stream position used for Last-Event-ID, EventID identifies the change itself
+// and is the same on every instance and every redelivery
+type Event struct {
+	ID      string      `json:"id"`
+	EventID string      `json:"event_id"`
+	Type    string      `json:"type"`
+	NoteID  uuid.UUID   `json:"note_id"`
+	Data    interface{} `json:"data"`
+	// the user allowed to see this event
+	UserID uuid.UUID `json:"-"`
+
+	seq uint64
+}
+
+const (
+	// DefaultHistorySize is how many events a broker keeps for Last-Event-ID replay
+	DefaultHistorySize = 1000
+	// events queued for a slow subscriber before it is dropped
+	subscriberBuffer = 64
+
+	// bus topic that carries note and task changes
+	changesTopic = "changes"
+)
+
+// Broker sends events through the bus and fans whatever the bus delivers
+// out to local subscribers, remembering the most recent ones so a
+// reconnecting client can resume where it left off. Event ids are prefixed
+// with the broker's instance id because sequences are only meaningful to
+// the broker that assigned them
+type Broker struct {
+	bus         Bus
+	mu          sync.Mutex
+	instance    string
+	seq         uint64
+	history     []Event
+	historySize int
+	// event ids in history, a redelivered event is dropped
+	seen        map[string]struct{}
+	subscribers map[*Subscription]struct{}
+	// closed when the server shuts down, streams end with closeReason
+	done        chan struct{}
+	closeReason string
+}
+
+// Subscription receives the events for a single user
+type Subscription struct {
+	UserID uuid.UUID
+	C      chan Event
+}
+
+// busEvent is how an event travels between instances
+type busEvent struct {
+	EventID string          `json:"event_id"`
+	Type    string          `json:"type"`
+	NoteID  uuid.UUID       `json:"note_id"`
+	UserID  uuid.UUID       `json:"user_id"`
+	Data    json.RawMessage `json:"data"`
+}
+
+func NewBroker(bus Bus, historySize int) *Broker {
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+
+	b := &Broker{
+		bus:         bus,
+		instance:    hex.EncodeToString(id),
+		historySize: historySize,
+		seen:        map[string]struct{}{},
+		subscribers: map[*Subscription]struct{}{},
+		done:        make(chan struct{}),
+	}
+	bus.Subscribe(changesTopic, b.receive)
+
+	return b
+}
+
+// Publish sends the event to the brokers on every instance
+func (b *Broker) Publish(event Event) {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Failed to encode event")
+		return
+	}
+
+	payload, err := json.Marshal(busEvent{EventID: event.EventID, Type: event.Type, NoteID: event.NoteID, UserID: event.UserID, Data: data})
+	if err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Failed to encode event")
+		return
+	}
+
+	if err := b.bus.Publish(context.Background(), changesTopic, payload); err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Failed to publish event")
+	}
+}
+
+// receive turns a message from the bus back into an event for local delivery
+func (b *Broker) receive(payload []byte) {
+	var message busEvent
+	if err := json.Unmarshal(payload, &message); err != nil {
+		log.Error().Err(err).Msg("Invalid event on bus")
+		return
+	}
+
+	b.deliver(Event{EventID: message.EventID, Type: message.Type, NoteID: message.NoteID, UserID: message.UserID, Data: message.Data})
+}
+
+// deliver assigns the event an id and hands it to every matching subscriber
+func (b *Broker) deliver(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if event.EventID != "" {
+		if _, ok := b.seen[event.EventID]; ok {
+			return
+		}
+		b.seen[event.EventID] = struct{}{}
+	}
+
+	b.seq++
+	event.seq = b.seq
+	event.ID = fmt.Sprintf("%s-%d", b.instance, b.seq)
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		for _, dropped := range b.history[:len(b.history)-b.historySize] {
+			delete(b.seen, dropped.EventID)
+		}
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers {
+		if sub.UserID != event.UserID {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+			// the client is not keeping up, it will resume from its last id
+			delete(b.subscribers, sub)
+			close(sub.C)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for userID. When lastEventID is set the
+// events after it are returned for replay, reset is true when they are no
+// longer available and the client has to reload its state
+func (b *Broker) Subscribe(userID uuid.UUID, lastEventID string) (sub *Subscription, replay []Event, reset bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{UserID: userID, C: make(chan Event, subscriberBuffer)}
+	b.subscribers[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, false
+	}
+
+	lastSeq, ok := b.parseID(lastEventID)
+	if !ok || lastSeq > b.seq {
+		return sub, nil, true
+	}
+
+	// the history has already dropped events the client has not seen
+	if len(b.history) > 0 && b.history[0].seq > lastSeq+1 {
+		return sub, nil, true
+	}
+
+	for _, event := range b.history {
+		if event.seq > lastSeq && event.UserID == userID {
+			replay = append(replay, event)
+		}
+	}
+	return sub, replay, false
+}
+
+// Unsubscribe removes the subscriber and closes its channel
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.C)
+	}
+}
+
+// Close tells every open stream to end with reason, streams opened
+// afterwards end straight away. Calling it again does nothing
+func (b *Broker) Close(reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+	default:
+		b.closeReason = reason
+		close(b.done)
+	}
+}
+
+// Done is closed once Close has been called
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// CloseReason is the reason given to Close
+func (b *Broker) CloseReason() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.closeReason
+}
+
+// parseID returns the sequence of an event id assigned by this broker
+func (b *Broker) parseID(id string) (uint64, bool) {
+	instance, seq, ok := strings.Cut(id, "-")
+	if !ok || instance != b.instance {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
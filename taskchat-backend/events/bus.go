@@ -0,0 +1,81 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// Bus carries messages between every running instance. A message published
+// on a topic is handed to all subscribers of that topic on every instance,
+// including the one that published it
+type Bus interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	// Subscribe registers handler for topic, the returned func removes it
+	Subscribe(topic string, handler func(payload []byte)) (unsubscribe func())
+	Close() error
+}
+
+// handlerSet is the topic to subscriber bookkeeping shared by the bus implementations
+type handlerSet struct {
+	mu       sync.RWMutex
+	nextID   int
+	handlers map[string]map[int]func([]byte)
+}
+
+func (h *handlerSet) add(topic string, handler func([]byte)) func() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.handlers == nil {
+		h.handlers = map[string]map[int]func([]byte){}
+	}
+	if h.handlers[topic] == nil {
+		h.handlers[topic] = map[int]func([]byte){}
+	}
+
+	h.nextID++
+	id := h.nextID
+	h.handlers[topic][id] = handler
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.handlers[topic], id)
+	}
+}
+
+func (h *handlerSet) dispatch(topic string, payload []byte) {
+	h.mu.RLock()
+	handlers := make([]func([]byte), 0, len(h.handlers[topic]))
+	for _, handler := range h.handlers[topic] {
+		handlers = append(handlers, handler)
+	}
+	h.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(payload)
+	}
+}
+
+// MemoryBus only reaches subscribers in this process, it is enough for a
+// single instance
+type MemoryBus struct {
+	handlers handlerSet
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+func (b *MemoryBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.handlers.dispatch(topic, payload)
+	return nil
+}
+
+func (b *MemoryBus) Subscribe(topic string, handler func(payload []byte)) func() {
+	return b.handlers.add(topic, handler)
+}
+
+func (b *MemoryBus) Close() error {
+	return nil
+}
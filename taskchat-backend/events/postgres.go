@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+const (
+	// every topic shares one notification channel, the topic travels in the payload
+	postgresChannel = "taskchat_events"
+	// NOTIFY payloads must be shorter than 8000 bytes
+	maxNotifyPayload = 7900
+)
+
+// PostgresBus fans messages out to every instance connected to the same
+// database with LISTEN/NOTIFY. Notifications sent while the listener is
+// reconnecting are not redelivered
+type PostgresBus struct {
+	db       *gorm.DB
+	dsn      string
+	handlers handlerSet
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+type notification struct {
+	Topic   string          `json:"topic"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// NewPostgresBus publishes through db and listens on a dedicated connection
+// opened from dsn
+func NewPostgresBus(db *gorm.DB, dsn string) *PostgresBus {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	b := &PostgresBus{
+		db:     db,
+		dsn:    dsn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go b.listen(ctx)
+
+	return b
+}
+
+// Publish sends payload, which must be JSON, to every instance
+func (b *PostgresBus) Publish(ctx context.Context, topic string, payload []byte) error {
+	message, err := json.Marshal(notification{Topic: topic, Payload: payload})
+	if err != nil {
+		return err
+	}
+	if len(message) > maxNotifyPayload {
+		return fmt.Errorf("event of %d bytes is too large for NOTIFY", len(message))
+	}
+
+	return b.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", postgresChannel, string(message)).Error
+}
+
+func (b *PostgresBus) Subscribe(topic string, handler func(payload []byte)) func() {
+	return b.handlers.add(topic, handler)
+}
+
+// Close stops the listener and waits for its connection to close
+func (b *PostgresBus) Close() error {
+	b.cancel()
+	<-b.done
+	return nil
+}
+
+// listen keeps a LISTEN connection open, reconnecting with backoff until closed
+func (b *PostgresBus) listen(ctx context.Context) {
+	defer close(b.done)
+
+	backoff := time.Second
+	for {
+		connected, err := b.listenOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+
+		log.Error().Err(err).Dur("retry_in", backoff).Msg("Event bus listener disconnected")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// listenOnce reports whether it got as far as listening before failing
+func (b *PostgresBus) listenOnce(ctx context.Context) (bool, error) {
+	conn, err := pgx.Connect(ctx, b.dsn)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+postgresChannel); err != nil {
+		return false, err
+	}
+	log.Info().Msg("Event bus listening")
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+
+		var message notification
+		if err := json.Unmarshal([]byte(n.Payload), &message); err != nil {
+			log.Error().Err(err).Msg("Invalid event bus notification")
+			continue
+		}
+		b.handlers.dispatch(message.Topic, message.Payload)
+	}
+}
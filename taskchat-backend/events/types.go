@@ -0,0 +1,21 @@
+package events
+
+const (
+	NoteCreated  = "note.created"
+	NoteUpdated  = "note.updated"
+	NoteDeleted  = "note.deleted"
+	NoteRestored = "note.restored"
+	TaskCreated  = "task.created"
+	TaskUpdated  = "task.updated"
+	TaskDeleted  = "task.deleted"
+	TaskRestored = "task.restored"
+
+	// pushed to open streams only, presence is never sent to webhooks
+	PresenceChanged = "presence.changed"
+)
+
+// Types lists every change event a client or webhook can receive
+var Types = []string{
+	NoteCreated, NoteUpdated, NoteDeleted, NoteRestored,
+	TaskCreated, TaskUpdated, TaskDeleted, TaskRestored,
+}
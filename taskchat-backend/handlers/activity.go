@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"taskchat/models"
+	"taskchat/repository"
+	"taskchat/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 100
+)
+
+// recordActivity stores a domain event for the activity feeds
+func recordActivity(store repository.Store, userID, noteID uuid.UUID, taskID *uuid.UUID, activityType models.ActivityType, data models.JSON) error {
+	activity := models.Activity{
+		ID:     uuid.New(),
+		UserID: userID,
+		NoteID: noteID,
+		TaskID: taskID,
+		Type:   string(activityType),
+		Data:   data,
+	}
+
+	if err := store.Activity().Create(&activity); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to record activity")
+		return newAPIError(fiber.StatusInternalServerError, "Failed to record activity")
+	}
+	return nil
+}
+
+// get the activity feed of a single note
+func (h *Handler) GetNoteActivity(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get the note id from params
+	noteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid note id")
+	}
+
+	// the feed stays readable while the note is in the trash
+	if _, err := h.storeFor(c).Notes().FindAny(userID, noteID); err != nil {
+		return utils.NotFound(c, "Note not found")
+	}
+
+	limit, after, err := activityPage(c)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	activities, err := h.storeFor(c).Activity().ListByNote(noteID, after, limit)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch activity")
+		return utils.InternalError(c, "Failed to fetch activity")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"activities": activities, "next_cursor": nextActivityCursor(activities, limit)})
+}
+
+// get what happened across all of the user's notes since a point in time
+func (h *Handler) GetActivity(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// defaults to the last day when the client does not say when it was last here
+	since := time.Now().Add(-24 * time.Hour)
+	if raw := c.Query("since"); raw != "" {
+		since, err = time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return utils.BadRequest(c, "since must be an RFC3339 timestamp")
+		}
+	}
+
+	limit, after, err := activityPage(c)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// how many of each event happened in the whole window
+	summary, err := h.storeFor(c).Activity().CountByUser(userID, since)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to summarise activity")
+		return utils.InternalError(c, "Failed to fetch activity")
+	}
+
+	activities, err := h.storeFor(c).Activity().ListByUser(userID, since, after, limit)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch activity")
+		return utils.InternalError(c, "Failed to fetch activity")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{
+		"since":       since,
+		"summary":     summary,
+		"activities":  activities,
+		"next_cursor": nextActivityCursor(activities, limit),
+	})
+}
+
+// activityPage reads the limit and cursor query params
+func activityPage(c *fiber.Ctx) (int, repository.ActivityCursor, error) {
+	limit := c.QueryInt("limit", defaultActivityLimit)
+	if limit < 1 || limit > maxActivityLimit {
+		return 0, repository.ActivityCursor{}, newAPIError(fiber.StatusBadRequest, "limit must be between 1 and 100")
+	}
+
+	var after repository.ActivityCursor
+	if raw := c.Query("cursor"); raw != "" {
+		var err error
+		after, err = decodeActivityCursor(raw)
+		if err != nil {
+			return 0, repository.ActivityCursor{}, newAPIError(fiber.StatusBadRequest, "Invalid cursor")
+		}
+	}
+
+	return limit, after, nil
+}
+
+// nextActivityCursor is the cursor of the next page, empty on the last page.
+// clients pass it back as is, what is inside is not part of the api
+func nextActivityCursor(activities []models.Activity, limit int) string {
+	if len(activities) < limit {
+		return ""
+	}
+	last := activities[len(activities)-1]
+	raw := last.CreatedAt.Format(time.RFC3339Nano) + "," + last.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeActivityCursor reverses nextActivityCursor
+func decodeActivityCursor(cursor string) (repository.ActivityCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return repository.ActivityCursor{}, err
+	}
+
+	createdAt, id, found := strings.Cut(string(raw), ",")
+	if !found {
+		return repository.ActivityCursor{}, errors.New("cursor has no id")
+	}
+
+	var after repository.ActivityCursor
+	if after.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return repository.ActivityCursor{}, err
+	}
+	if after.ID, err = uuid.Parse(id); err != nil {
+		return repository.ActivityCursor{}, err
+	}
+	return after, nil
+}
+
+// recordTaskActivity records the feed events for the difference between a
+// task's previous snapshot and its current state
+func recordTaskActivity(store repository.Store, userID uuid.UUID, before models.JSON, task *models.Task) error {
+	if before["status"] != task.Status {
+		activityType := models.ActivityTaskReopened
+		if task.Status == string(models.StatusCompleted) {
+			activityType = models.ActivityTaskCompleted
+		}
+		if err := recordActivity(store, userID, task.NoteID, &task.ID, activityType, models.JSON{"title": task.Title}); err != nil {
+			return err
+		}
+	}
+
+	if before["priority"] != task.Priority {
+		data := models.JSON{"title": task.Title, "from": before["priority"], "to": task.Priority}
+		if err := recordActivity(store, userID, task.NoteID, &task.ID, models.ActivityTaskReprioritized, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordNoteRename records a rename event when the title changed
+func recordNoteRename(store repository.Store, userID uuid.UUID, before models.JSON, note *models.Note) error {
+	if before["title"] == note.Title {
+		return nil
+	}
+	return recordActivity(store, userID, note.ID, nil, models.ActivityNoteRenamed, models.JSON{"from": before["title"], "to": note.Title})
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"taskchat/audit"
+	"taskchat/models"
+	"taskchat/repository"
+	"taskchat/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// recordAudit appends an audit entry for the current request, a failure is
+// logged but never fails the request itself
+func (h *Handler) recordAudit(c *fiber.Ctx, entry audit.Entry) {
+	entry.IP = c.IP()
+	entry.UserAgent = c.Get(fiber.HeaderUserAgent)
+
+	if err := audit.Record(h.storeFor(c), entry); err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Str("event", entry.Event).Msg("Failed to write audit log")
+	}
+}
+
+// list audit log entries, newest first
+func (h *Handler) GetAuditLogs(c *fiber.Ctx) error {
+
+	filter, err := auditFilter(c)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	limit := c.QueryInt("limit", defaultAuditLimit)
+	if limit < 1 || limit > maxAuditLimit {
+		return utils.BadRequest(c, "limit must be between 1 and 1000")
+	}
+
+	// page backwards through the chain with the seq of the last entry seen
+	filter.BeforeSeq = int64(c.QueryInt("before_seq", 0))
+
+	entries, err := h.storeFor(c).Audit().List(filter, limit)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch audit logs")
+		return utils.InternalError(c, "Failed to fetch audit logs")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"entries": entries})
+}
+
+// stream matching audit log entries in chain order as JSON lines
+func (h *Handler) ExportAuditLogs(c *fiber.Ctx) error {
+
+	filter, err := auditFilter(c)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// the writer runs after the handler returns and c is reused by another
+	// request, so it only touches what is taken out of c here
+	ctx := c.UserContext()
+	store := h.storeFor(c)
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.jsonl"`)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+
+		err := store.Audit().Export(filter, func(batch []models.AuditLog) error {
+			for i := range batch {
+				if err := encoder.Encode(&batch[i]); err != nil {
+					return err
+				}
+			}
+			return w.Flush()
+		})
+		if err != nil {
+			log.Error().Ctx(ctx).Err(err).Msg("Failed to export audit logs")
+		}
+	})
+
+	return nil
+}
+
+// recompute the hash chain and report the first entry that does not match
+func (h *Handler) VerifyAuditLog(c *fiber.Ctx) error {
+
+	result, err := audit.Verify(h.storeFor(c))
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to verify audit log")
+		return utils.InternalError(c, "Failed to verify audit log")
+	}
+
+	// return response
+	return utils.Success(c, result)
+}
+
+// auditFilter reads the event, user_id, email, ip, success, from and to
+// filters. The strings are copied, fiber reuses the request's memory once
+// the handler returns and the export outlives it
+func auditFilter(c *fiber.Ctx) (repository.AuditFilter, error) {
+	filter := repository.AuditFilter{
+		Event: strings.Clone(c.Query("event")),
+		Email: strings.Clone(c.Query("email")),
+		IP:    strings.Clone(c.Query("ip")),
+	}
+
+	if raw := c.Query("user_id"); raw != "" {
+		userID, err := uuid.Parse(raw)
+		if err != nil {
+			return filter, newAPIError(fiber.StatusBadRequest, "Invalid user id")
+		}
+		filter.UserID = &userID
+	}
+
+	switch c.Query("success") {
+	case "":
+	case "true", "false":
+		success := c.Query("success") == "true"
+		filter.Success = &success
+	default:
+		return filter, newAPIError(fiber.StatusBadRequest, "success must be true or false")
+	}
+
+	for param, bound := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, newAPIError(fiber.StatusBadRequest, param+" must be an RFC3339 timestamp")
+		}
+		*bound = t
+	}
+
+	return filter, nil
+}
@@ -3,7 +3,8 @@ package handlers
 import (
 	"regexp"
 	"strings"
-	"taskchat/database"
+	"taskchat/audit"
+	"taskchat/metrics"
 	"taskchat/models"
 	"taskchat/utils"
 
@@ -29,7 +30,7 @@ type LoginRequest struct {
 var emailRegex = regexp.MustCompile(`(?i)^[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`)
 
 // function for the signup
-func Register(c *fiber.Ctx) error {
+func (h *Handler) Register(c *fiber.Ctx) error {
 
 	// making the varible for the like storing the req body
 	var req RegisterRequest
@@ -53,16 +54,15 @@ func Register(c *fiber.Ctx) error {
 	}
 
 	// checking if the email already exits or not creating a variable
-	var existingEmail models.User
-	// checks in db is the email is present than it attaches to the varibles, .error tells the there is no error while running the query
-	if err := database.DB.Where("email=?", req.Email).First(&existingEmail).Error; err == nil {
+	// checks in db is the email is present, no error means a user was found
+	if _, err := h.storeFor(c).Users().FindByEmail(req.Email); err == nil {
 		return utils.Conflict(c, "Email alreasy exits")
 	}
 
 	// byte converts the data into single words and than hashes it
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to hash password")
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to hash password")
 		return utils.InternalError(c, "Failed to process request")
 	}
 
@@ -74,16 +74,18 @@ func Register(c *fiber.Ctx) error {
 	}
 
 	// creating and saving the created user
-	err = database.DB.Create(&user).Error
+	err = h.storeFor(c).Users().Create(&user)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create users")
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to create users")
 		return utils.InternalError(c, "Failed to create user ")
 	}
 
+	h.recordAudit(c, audit.Entry{Event: audit.EventRegister, UserID: &user.ID, Email: user.Email, Success: true})
+
 	//gemerating token
 	token, err := utils.GenerateJWT(user.ID, user.Email)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate JWT")
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to generate JWT")
 		return utils.Conflict(c, "Failed to generate token")
 	}
 
@@ -92,7 +94,7 @@ func Register(c *fiber.Ctx) error {
 
 }
 
-func Login(c *fiber.Ctx) error {
+func (h *Handler) Login(c *fiber.Ctx) error {
 
 	var req LoginRequest
 	if err := c.BodyParser(&req); err != nil {
@@ -106,23 +108,30 @@ func Login(c *fiber.Ctx) error {
 		return utils.BadRequest(c, "Email and password are required")
 	}
 
-	var user models.User
-	if err := database.DB.Where("email=?", req.Email).First(&user).Error; err != nil {
-		log.Error().Err(err).Msg("User not found in DB")
+	user, err := h.storeFor(c).Users().FindByEmail(req.Email)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("User not found in DB")
+		h.recordAudit(c, audit.Entry{Event: audit.EventLoginFailure, Email: req.Email, Metadata: map[string]string{"reason": "unknown_email"}})
+		metrics.Logins.WithLabelValues("failure").Inc()
 		return utils.Unauthorized(c, "Invalid email or password")
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		log.Error().Err(err).Msg("Password missmatch")
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Password missmatch")
+		h.recordAudit(c, audit.Entry{Event: audit.EventLoginFailure, UserID: &user.ID, Email: user.Email, Metadata: map[string]string{"reason": "wrong_password"}})
+		metrics.Logins.WithLabelValues("failure").Inc()
 		return utils.Unauthorized(c, "Invalid email or password")
 	}
 
 	token, err := utils.GenerateJWT(user.ID, user.Email)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to generate JWT")
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to generate JWT")
 		return utils.InternalError(c, "Could not login, try again")
 	}
 
+	h.recordAudit(c, audit.Entry{Event: audit.EventLoginSuccess, UserID: &user.ID, Email: user.Email, Success: true})
+	metrics.Logins.WithLabelValues("success").Inc()
+
 	// return response
 	return utils.Created(c, fiber.Map{"token": token, "user": fiber.Map{"id": user.ID, "email": user.Email}})
 
@@ -130,10 +139,10 @@ func Login(c *fiber.Ctx) error {
 
 func ErrorHandler(c *fiber.Ctx, err error) error {
 	if e, ok := err.(*fiber.Error); ok {
-		return c.Status(e.Code).JSON(fiber.Map{"success": false, "error": e.Message})
+		return utils.Error(c, e.Code, e.Message)
 	}
 
-	log.Error().Err(err).Msg("unexpected error")
+	log.Error().Ctx(c.UserContext()).Err(err).Msg("unexpected error")
 
 	// return response
 	return utils.InternalError(c, "Error by error handler")
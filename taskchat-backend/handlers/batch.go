@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"taskchat/metrics"
+	"taskchat/repository"
+	"taskchat/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// every operation succeeds or the whole batch is rolled back
+	BatchModeAtomic = "atomic"
+	// each operation is committed or rolled back on its own
+	BatchModePartial = "partial"
+
+	maxBatchOperations = 100
+)
+
+// type of schema for a batch request
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// a single create, update or delete on a note or task
+type BatchOperation struct {
+	Op     string          `json:"op"`
+	Type   string          `json:"type"`
+	ID     string          `json:"id,omitempty"`
+	NoteID string          `json:"note_id,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// outcome of one operation, status is the code the single item route would return
+type BatchResult struct {
+	Index  int         `json:"index"`
+	Status int         `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// errBatchRolledBack makes the transaction roll back in atomic mode
+var errBatchRolledBack = errors.New("batch rolled back")
+
+// run several note and task operations in one transaction
+func (h *Handler) Batch(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// parse req body
+	var req BatchRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.BadRequest(c, "Invalid req body ")
+	}
+
+	if req.Mode == "" {
+		req.Mode = BatchModeAtomic
+	}
+	if req.Mode != BatchModeAtomic && req.Mode != BatchModePartial {
+		return utils.BadRequest(c, "Mode must be atomic or partial")
+	}
+
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+		return utils.BadRequest(c, fmt.Sprintf("Batch must contain between 1 and %d operations", maxBatchOperations))
+	}
+
+	results := make([]BatchResult, len(req.Operations))
+	failed := false
+
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		for i, op := range req.Operations {
+
+			// in partial mode a failed operation only rolls back to its own savepoint
+			savepoint := fmt.Sprintf("batch_op_%d", i)
+			if req.Mode == BatchModePartial {
+				if err := tx.SavePoint(savepoint); err != nil {
+					return err
+				}
+			}
+
+			data, status, err := runBatchOperation(tx, userID, op)
+			if err != nil {
+				failed = true
+				results[i] = BatchResult{Index: i, Status: errorStatus(err), Error: batchErrorMessage(c, err)}
+
+				if req.Mode == BatchModeAtomic {
+					// the remaining operations are never attempted
+					for j := i + 1; j < len(req.Operations); j++ {
+						results[j] = BatchResult{Index: j, Status: fiber.StatusFailedDependency, Error: "Not executed"}
+					}
+					return errBatchRolledBack
+				}
+
+				if err := tx.RollbackTo(savepoint); err != nil {
+					return err
+				}
+				continue
+			}
+
+			results[i] = BatchResult{Index: i, Status: status, Data: data}
+		}
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errBatchRolledBack) {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to run batch")
+		return utils.InternalError(c, "Failed to run batch")
+	}
+
+	committed := err == nil
+	if !committed {
+		body := utils.ErrorBody(c, "Batch rolled back")
+		body["data"] = fiber.Map{"committed": false, "results": results}
+		return c.Status(fiber.StatusBadRequest).JSON(body)
+	}
+
+	// only count what was committed, failed operations were rolled back to their savepoint
+	for i, op := range req.Operations {
+		if op.Op != "create" || results[i].Status != fiber.StatusCreated {
+			continue
+		}
+		switch op.Type {
+		case "note":
+			metrics.NotesCreated.Inc()
+		case "task":
+			metrics.TasksCreated.Inc()
+		}
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"committed": true, "partial": failed, "results": results})
+}
+
+// runBatchOperation dispatches one operation to the shared note and task logic
+func runBatchOperation(tx repository.Store, userID uuid.UUID, op BatchOperation) (interface{}, int, error) {
+	switch op.Type + ":" + op.Op {
+	case "note:create":
+		var req NoteRequest
+		if err := decodeBatchData(op, &req); err != nil {
+			return nil, 0, err
+		}
+		note, err := createNote(tx, userID, req)
+		return note, fiber.StatusCreated, err
+
+	case "note:update":
+		noteID, err := parseBatchID(op.ID, "Invalid note id")
+		if err != nil {
+			return nil, 0, err
+		}
+		var req NoteRequest
+		if err := decodeBatchData(op, &req); err != nil {
+			return nil, 0, err
+		}
+		note, err := updateNote(tx, userID, noteID, req)
+		return note, fiber.StatusOK, err
+
+	case "note:delete":
+		noteID, err := parseBatchID(op.ID, "Invalid note id")
+		if err != nil {
+			return nil, 0, err
+		}
+		note, err := deleteNote(tx, userID, noteID)
+		return note, fiber.StatusOK, err
+
+	case "task:create":
+		noteID, err := parseBatchID(op.NoteID, "Invalid note id")
+		if err != nil {
+			return nil, 0, err
+		}
+		var req TaskRequest
+		if err := decodeBatchData(op, &req); err != nil {
+			return nil, 0, err
+		}
+		task, err := createTask(tx, userID, noteID, req)
+		return task, fiber.StatusCreated, err
+
+	case "task:update":
+		taskID, err := parseBatchID(op.ID, "Invalid task id")
+		if err != nil {
+			return nil, 0, err
+		}
+		var req TaskRequest
+		if err := decodeBatchData(op, &req); err != nil {
+			return nil, 0, err
+		}
+		task, err := updateTask(tx, userID, taskID, req)
+		return task, fiber.StatusOK, err
+
+	case "task:delete":
+		taskID, err := parseBatchID(op.ID, "Invalid task id")
+		if err != nil {
+			return nil, 0, err
+		}
+		task, err := deleteTask(tx, userID, taskID)
+		return task, fiber.StatusOK, err
+	}
+
+	return nil, 0, newAPIError(fiber.StatusBadRequest, "Unknown operation, op must be create, update or delete and type must be note or task")
+}
+
+func decodeBatchData(op BatchOperation, v interface{}) error {
+	if len(op.Data) == 0 {
+		return newAPIError(fiber.StatusBadRequest, "Operation data is required")
+	}
+	if err := json.Unmarshal(op.Data, v); err != nil {
+		return newAPIError(fiber.StatusBadRequest, "Invalid operation data")
+	}
+	return nil
+}
+
+func parseBatchID(raw, message string) (uuid.UUID, error) {
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.UUID{}, newAPIError(fiber.StatusBadRequest, message)
+	}
+	return id, nil
+}
+
+// batchErrorMessage is what the client is told about a failed operation, only
+// apiError messages are meant for it, anything else is logged and hidden
+func batchErrorMessage(c *fiber.Ctx, err error) string {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.message
+	}
+	log.Error().Ctx(c.UserContext()).Err(err).Msg("Batch operation failed")
+	return "Internal server error"
+}
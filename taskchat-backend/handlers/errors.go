@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"errors"
+	"taskchat/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// apiError is returned by the shared note and task operations so the single
+// item handlers and the batch endpoint report the same status and message
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func newAPIError(status int, message string) *apiError {
+	return &apiError{status: status, message: message}
+}
+
+// sendError writes an apiError with its own status, anything else is a 500
+func sendError(c *fiber.Ctx, err error) error {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return utils.Error(c, apiErr.status, apiErr.message)
+	}
+	return utils.InternalError(c, "Something went wrong")
+}
+
+// errorStatus returns the HTTP status that matches err
+func errorStatus(err error) int {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.status
+	}
+	return fiber.StatusInternalServerError
+}
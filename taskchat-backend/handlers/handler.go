@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"taskchat/events"
+	"taskchat/presence"
+	"taskchat/repository"
+	"taskchat/webhooks"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Handler serves the API from the store, broker, presence tracker and
+// webhook service it is given rather than package level ones, so tests and
+// other setups can run their own
+type Handler struct {
+	store    repository.Store
+	hub      *events.Broker
+	presence *presence.Tracker
+	webhooks *webhooks.Service
+}
+
+func New(store repository.Store, hub *events.Broker, tracker *presence.Tracker, hooks *webhooks.Service) *Handler {
+	return &Handler{store: store, hub: hub, presence: tracker, webhooks: hooks}
+}
+
+// storeFor binds the store to the request, queries made through it stop when
+// the client goes away and show up in the request's trace
+func (h *Handler) storeFor(c *fiber.Ctx) repository.Store {
+	return h.store.WithContext(c.UserContext())
+}
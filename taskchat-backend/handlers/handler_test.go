@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"taskchat/events"
+	"taskchat/models"
+	"taskchat/presence"
+	"taskchat/repository"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+// fakeStore keeps notes in memory and records the outbox events written
+// through it. The embedded interfaces are nil, so a handler reaching for
+// anything not faked here panics and fails the test
+type fakeStore struct {
+	repository.Store
+	notes    map[uuid.UUID]models.Note
+	outbox   []string
+	failNext error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{notes: map[uuid.UUID]models.Note{}}
+}
+
+func (s *fakeStore) Notes() repository.NoteRepository         { return fakeNotes{s: s} }
+func (s *fakeStore) Revisions() repository.RevisionRepository { return fakeRevisions{} }
+func (s *fakeStore) Activity() repository.ActivityRepository  { return fakeActivity{} }
+func (s *fakeStore) Reads() repository.ReadRepository         { return fakeReads{} }
+func (s *fakeStore) Outbox() repository.OutboxRepository      { return fakeOutbox{s: s} }
+func (s *fakeStore) Context() context.Context                 { return context.Background() }
+
+func (s *fakeStore) WithContext(ctx context.Context) repository.Store { return s }
+
+func (s *fakeStore) Transaction(fn func(tx repository.Store) error) error {
+	return fn(s)
+}
+
+type fakeNotes struct {
+	repository.NoteRepository
+	s *fakeStore
+}
+
+func (r fakeNotes) ListByUser(userID uuid.UUID) ([]models.Note, error) {
+	var notes []models.Note
+	for _, note := range r.s.notes {
+		if note.UserID == userID && !note.DeletedAt.Valid {
+			notes = append(notes, note)
+		}
+	}
+	return notes, nil
+}
+
+func (r fakeNotes) Find(userID, noteID uuid.UUID) (*models.Note, error) {
+	note, ok := r.s.notes[noteID]
+	if !ok || note.UserID != userID || note.DeletedAt.Valid {
+		return nil, repository.ErrNotFound
+	}
+	return &note, nil
+}
+
+func (r fakeNotes) Create(note *models.Note) error {
+	if err := r.s.failNext; err != nil {
+		r.s.failNext = nil
+		return err
+	}
+	r.s.notes[note.ID] = *note
+	return nil
+}
+
+func (r fakeNotes) Save(note *models.Note) error {
+	r.s.notes[note.ID] = *note
+	return nil
+}
+
+type fakeRevisions struct{ repository.RevisionRepository }
+
+func (fakeRevisions) Create(*models.Revision) error { return nil }
+
+type fakeActivity struct{ repository.ActivityRepository }
+
+func (fakeActivity) Create(*models.Activity) error { return nil }
+
+type fakeReads struct{ repository.ReadRepository }
+
+func (fakeReads) ListByNotes(uuid.UUID, []uuid.UUID) ([]models.NoteRead, error) { return nil, nil }
+
+func (fakeReads) CountUnreadTasks(uuid.UUID, []uuid.UUID) (map[uuid.UUID]int64, error) {
+	return map[uuid.UUID]int64{}, nil
+}
+
+type fakeOutbox struct {
+	repository.OutboxRepository
+	s *fakeStore
+}
+
+func (r fakeOutbox) Record(eventType string, userID, noteID uuid.UUID, data interface{}) error {
+	r.s.outbox = append(r.s.outbox, eventType)
+	return nil
+}
+
+// newFakeApp serves the note routes from store, the user is whoever the
+// X-User-ID header names
+func newFakeApp(store repository.Store) *fiber.App {
+	bus := events.NewMemoryBus()
+	hub := events.NewBroker(bus, 10)
+	h := New(store, hub, presence.NewTracker(bus, hub), nil)
+
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user_id", uuid.MustParse(c.Get("X-User-ID")))
+		return c.Next()
+	})
+	app.Get("/notes", h.GetNotes)
+	app.Post("/notes", h.CreateNote)
+	app.Put("/notes/:id", h.UpdateNote)
+	return app
+}
+
+func sendFake(t *testing.T, app *fiber.App, method, path string, userID uuid.UUID, body string) (int, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", userID.String())
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatalf("%s %s returned %q: %v", method, path, raw, err)
+	}
+	return resp.StatusCode, decoded
+}
+
+func TestNoteHandlersWithFakeStore(t *testing.T) {
+	// the handlers log the failure the test provokes
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+	store := newFakeStore()
+	app := newFakeApp(store)
+	alice, bob := uuid.New(), uuid.New()
+
+	status, body := sendFake(t, app, http.MethodPost, "/notes", alice, `{"title":"Groceries"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("create returned %d %v", status, body)
+	}
+	noteID := body["data"].(map[string]interface{})["ID"].(string)
+	if len(store.outbox) != 1 || store.outbox[0] != events.NoteCreated {
+		t.Fatalf("unexpected outbox %v", store.outbox)
+	}
+
+	status, body = sendFake(t, app, http.MethodGet, "/notes", alice, "")
+	if notes := body["data"].(map[string]interface{})["notes"].([]interface{}); status != http.StatusOK || len(notes) != 1 {
+		t.Fatalf("list returned %d %v", status, body)
+	}
+
+	// another user cannot see or change the note
+	status, body = sendFake(t, app, http.MethodGet, "/notes", bob, "")
+	if notes, _ := body["data"].(map[string]interface{})["notes"].([]interface{}); status != http.StatusOK || len(notes) != 0 {
+		t.Fatalf("list as another user returned %d %v", status, body)
+	}
+	if status, body = sendFake(t, app, http.MethodPut, "/notes/"+noteID, bob, `{"title":"Mine"}`); status != http.StatusNotFound {
+		t.Fatalf("update as another user returned %d %v", status, body)
+	}
+	if store.notes[uuid.MustParse(noteID)].Title != "Groceries" {
+		t.Fatalf("note changed by another user: %+v", store.notes)
+	}
+
+	// a failing store is reported without echoing the underlying error
+	store.failNext = errors.New("connection reset by peer")
+	status, body = sendFake(t, app, http.MethodPost, "/notes", alice, `{"title":"Lost"}`)
+	if body["error"] != "Failed to create note" || len(store.notes) != 1 {
+		t.Fatalf("failed create returned %d %v", status, body)
+	}
+}
+
+func TestBatchErrorMessage(t *testing.T) {
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.JSON([]string{
+			batchErrorMessage(c, newAPIError(fiber.StatusNotFound, "Note not found")),
+			batchErrorMessage(c, errors.New(`pq: relation "notes" does not exist`)),
+		})
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var messages []string
+	if err := json.NewDecoder(resp.Body).Decode(&messages); err != nil {
+		t.Fatal(err)
+	}
+	if messages[0] != "Note not found" || messages[1] != "Internal server error" {
+		t.Fatalf("unexpected messages %q", messages)
+	}
+}
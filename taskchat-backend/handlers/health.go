@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"taskchat/workers"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// longest a single readiness check may take before it counts as failed
+const readinessCheckTimeout = 2 * time.Second
+
+// HealthCheck is the outcome of one readiness check
+type HealthCheck struct {
+	Status    string      `json:"status"`
+	LatencyMs float64     `json:"latency_ms"`
+	Details   interface{} `json:"details,omitempty"`
+	// informational checks are reported but never make the instance not ready
+	Informational bool `json:"informational,omitempty"`
+}
+
+// the process is up and serving, nothing else is checked so a database
+// outage does not get every instance restarted
+func (h *Handler) Liveness(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "ok"})
+}
+
+// whether this instance can do useful work, 503 when it cannot
+func (h *Handler) Readiness(c *fiber.Ctx) error {
+
+	checks := map[string]HealthCheck{
+		"database":   runCheck(c.UserContext(), "database", h.checkDatabase),
+		"migrations": runCheck(c.UserContext(), "migrations", h.checkMigrations),
+		"workers":    runCheck(c.UserContext(), "workers", checkWorkers),
+	}
+
+	// a stuck worker is shared by every instance, one slow webhook endpoint
+	// would otherwise take the whole fleet out of rotation
+	workersCheck := checks["workers"]
+	workersCheck.Informational = true
+	checks["workers"] = workersCheck
+
+	//one failing check takes the instance out of rotation
+	status, code := "ready", fiber.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" && !check.Informational {
+			status, code = "not_ready", fiber.StatusServiceUnavailable
+		}
+	}
+
+	// return response
+	return c.Status(code).JSON(fiber.Map{"status": status, "checks": checks})
+}
+
+// runCheck times check and turns its error into a failed result. the probes
+// are not authenticated so the error itself only goes to the log
+func runCheck(ctx context.Context, name string, check func(ctx context.Context) (interface{}, error)) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	details, err := check(ctx)
+
+	result := HealthCheck{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000, Details: details}
+	if err != nil {
+		log.Warn().Ctx(ctx).Err(err).Str("check", name).Msg("Readiness check failing")
+		result.Status = "failing"
+	}
+	return result
+}
+
+// checkDatabase pings the pool and reports how busy it is
+func (h *Handler) checkDatabase(ctx context.Context) (interface{}, error) {
+	stats, err := h.store.Ping(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"open_connections": stats.OpenConnections,
+		"in_use":           stats.InUse,
+		"idle":             stats.Idle,
+		"max_open":         stats.MaxOpenConnections,
+		"wait_count":       stats.WaitCount,
+	}, nil
+}
+
+// checkMigrations fails while the schema is behind the binary
+func (h *Handler) checkMigrations(ctx context.Context) (interface{}, error) {
+	pending, err := h.store.PendingMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) > 0 {
+		return fiber.Map{"pending": pending}, fmt.Errorf("%d migrations not applied", len(pending))
+	}
+	return nil, nil
+}
+
+// checkWorkers fails when a background worker is stuck, failing or stopped,
+// it is reported for alerting and does not affect readiness
+func checkWorkers(ctx context.Context) (interface{}, error) {
+	report, healthy := workers.Health()
+	if !healthy {
+		return report, fmt.Errorf("background workers are not healthy")
+	}
+	return report, nil
+}
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"strings"
-	"taskchat/database"
+	"taskchat/events"
+	"taskchat/metrics"
 	"taskchat/models"
+	"taskchat/repository"
 	"taskchat/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -29,7 +31,7 @@ func getUserID(c *fiber.Ctx) (uuid.UUID, error) {
 }
 
 // get notes function
-func GetNotes(c *fiber.Ctx) error {
+func (h *Handler) GetNotes(c *fiber.Ctx) error {
 
 	//get the userID from the JWT
 	userID, err := getUserID(c)
@@ -38,18 +40,24 @@ func GetNotes(c *fiber.Ctx) error {
 	}
 
 	// fetch all the notes for that particular user
-	var notes []models.Note
-	if err := database.DB.Where("user_id=?", userID).Find(&notes).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to fetch notes")
+	notes, err := h.storeFor(c).Notes().ListByUser(userID)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch notes")
+		return utils.InternalError(c, "Failed to fetch notes")
+	}
+
+	withUnread, err := h.unreadCounts(c, userID, notes)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to count unread changes")
 		return utils.InternalError(c, "Failed to fetch notes")
 	}
 
 	// return response
-	return utils.Success(c, fiber.Map{"notes": notes})
+	return utils.Success(c, fiber.Map{"notes": withUnread})
 }
 
 // create notes function
-func CreateNote(c *fiber.Ctx) error {
+func (h *Handler) CreateNote(c *fiber.Ctx) error {
 
 	//get the userID from the JWT
 	userID, err := getUserID(c)
@@ -63,31 +71,22 @@ func CreateNote(c *fiber.Ctx) error {
 		return utils.BadRequest(c, "Invalid req body ")
 	}
 
-	// clean and santize req
-	req.Title = noteSanitizer.Sanitize(strings.TrimSpace(req.Title))
-	if req.Title == "" || len(req.Title) > 100 {
-		return utils.BadRequest(c, "Title is required and must under 100 characters")
-	}
-
-	// create note
-	note := models.Note{
-		ID:     uuid.New(),
-		UserID: userID,
-		Title:  req.Title,
-	}
-
-	// save note to database
-	if err := database.DB.Create(&note).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to create note")
-		return utils.BadRequest(c, "Failed to create note")
+	var note *models.Note
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		note, err = createNote(tx, userID, req)
+		return err
+	})
+	if err != nil {
+		return sendError(c, err)
 	}
+	metrics.NotesCreated.Inc()
 
 	// return response
 	return utils.Created(c, note)
 }
 
 // update notes function
-func UpdateNote(c *fiber.Ctx) error {
+func (h *Handler) UpdateNote(c *fiber.Ctx) error {
 
 	//get the userID from the JWT
 	userID, err := getUserID(c)
@@ -107,23 +106,13 @@ func UpdateNote(c *fiber.Ctx) error {
 		return utils.BadRequest(c, "Invalid req body ")
 	}
 
-	req.Title = noteSanitizer.Sanitize(strings.TrimSpace(req.Title))
-	if req.Title == "" || len(req.Title) > 100 {
-		return utils.BadRequest(c, "Title is required and mush under 100 characters")
-	}
-
-	// find the note from the db
-	var note models.Note
-	if err := database.DB.Where("id=? AND user_id=?", noteID, userID).First(&note).Error; err != nil {
-		log.Error().Err(err).Msg("Note not found")
-		return utils.NotFound(c, "Note not found")
-	}
-
-	//update the title
-	note.Title = req.Title
-	if err := database.DB.Save(&note).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to create note")
-		return utils.InternalError(c, "Failed to update note")
+	var note *models.Note
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		note, err = updateNote(tx, userID, noteID, req)
+		return err
+	})
+	if err != nil {
+		return sendError(c, err)
 	}
 
 	// return response
@@ -131,7 +120,7 @@ func UpdateNote(c *fiber.Ctx) error {
 }
 
 // delete notes function
-func DeleteNote(c *fiber.Ctx) error {
+func (h *Handler) DeleteNote(c *fiber.Ctx) error {
 
 	//get the userID from the JWT
 	userID, err := getUserID(c)
@@ -145,23 +134,122 @@ func DeleteNote(c *fiber.Ctx) error {
 		return utils.BadRequest(c, "Invalid note id")
 	}
 
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		_, err := deleteNote(tx, userID, noteID)
+		return err
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"message": "Note deleted successfully"})
+}
+
+// createNote validates the request and saves a new note for the user
+func createNote(store repository.Store, userID uuid.UUID, req NoteRequest) (*models.Note, error) {
+
+	// clean and santize req
+	req.Title = noteSanitizer.Sanitize(strings.TrimSpace(req.Title))
+	if req.Title == "" || len(req.Title) > 100 {
+		return nil, newAPIError(fiber.StatusBadRequest, "Title is required and must under 100 characters")
+	}
+
+	// create note
+	note := models.Note{
+		ID:     uuid.New(),
+		UserID: userID,
+		Title:  req.Title,
+	}
+
+	// save note to database
+	if err := store.Notes().Create(&note); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to create note")
+		return nil, newAPIError(fiber.StatusBadRequest, "Failed to create note")
+	}
+
+	if err := recordRevision(store, userID, entityNote, note.ID, models.RevisionCreated, nil, noteSnapshot(&note)); err != nil {
+		return nil, err
+	}
+
+	if err := recordActivity(store, userID, note.ID, nil, models.ActivityNoteCreated, models.JSON{"title": note.Title}); err != nil {
+		return nil, err
+	}
+
+	if err := queueNoteEvent(store, events.NoteCreated, &note); err != nil {
+		return nil, err
+	}
+
+	return &note, nil
+}
+
+// updateNote renames a note owned by the user
+func updateNote(store repository.Store, userID, noteID uuid.UUID, req NoteRequest) (*models.Note, error) {
+
+	req.Title = noteSanitizer.Sanitize(strings.TrimSpace(req.Title))
+	if req.Title == "" || len(req.Title) > 100 {
+		return nil, newAPIError(fiber.StatusBadRequest, "Title is required and mush under 100 characters")
+	}
+
+	// find the note from the db
+	note, err := store.Notes().Find(userID, noteID)
+	if err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Note not found")
+		return nil, newAPIError(fiber.StatusNotFound, "Note not found")
+	}
+
+	//update the title
+	before := noteSnapshot(note)
+	note.Title = req.Title
+	if err := store.Notes().Save(note); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to create note")
+		return nil, newAPIError(fiber.StatusInternalServerError, "Failed to update note")
+	}
+
+	if err := recordRevision(store, userID, entityNote, note.ID, models.RevisionUpdated, before, noteSnapshot(note)); err != nil {
+		return nil, err
+	}
+
+	if err := recordNoteRename(store, userID, before, note); err != nil {
+		return nil, err
+	}
+
+	if err := queueNoteEvent(store, events.NoteUpdated, note); err != nil {
+		return nil, err
+	}
+
+	return note, nil
+}
+
+// deleteNote moves a note owned by the user and its tasks to the trash
+func deleteNote(store repository.Store, userID, noteID uuid.UUID) (*models.Note, error) {
+
 	// find the note from the db
-	var note models.Note
-	if err := database.DB.Where("id=? AND user_id=?", noteID, userID).First(&note).Error; err != nil {
-		log.Error().Err(err).Msg("Note not found")
-		return utils.NotFound(c, "Note not found")
+	note, err := store.Notes().Find(userID, noteID)
+	if err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Note not found")
+		return nil, newAPIError(fiber.StatusNotFound, "Note not found")
 	}
 
-	if err := database.DB.Where("note_id=?", noteID).Delete(&models.Task{}).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to delete tasks")
-		return utils.InternalError(c, "Failed to delete tasks ")
+	// the note and its tasks share one timestamp so restoring the note only
+	// brings back the tasks that were trashed along with it
+	if err := store.Notes().Trash(note, trashTimestamp()); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to delete note")
+		return nil, newAPIError(fiber.StatusInternalServerError, "Failed to delete note")
 	}
 
-	if err := database.DB.Delete(&note).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to delete note")
-		return utils.InternalError(c, "Failed to delete note")
+	snapshot := noteSnapshot(note)
+	if err := recordRevision(store, userID, entityNote, note.ID, models.RevisionDeleted, snapshot, snapshot); err != nil {
+		return nil, err
 	}
 
-	// return response
-	return utils.Success(c, fiber.Map{"message": "Note deleted successfully"})
+	if err := recordActivity(store, userID, note.ID, nil, models.ActivityNoteDeleted, models.JSON{"title": note.Title}); err != nil {
+		return nil, err
+	}
+
+	if err := queueNoteEvent(store, events.NoteDeleted, note); err != nil {
+		return nil, err
+	}
+
+	return note, nil
 }
@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"taskchat/models"
+	"taskchat/repository"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// queueNoteEvent adds a note change to the outbox of the transaction making it
+func queueNoteEvent(tx repository.Store, eventType string, note *models.Note) error {
+	if err := tx.Outbox().Record(eventType, note.UserID, note.ID, note); err != nil {
+		log.Error().Ctx(tx.Context()).Err(err).Msg("Failed to record outbox event")
+		return newAPIError(fiber.StatusInternalServerError, "Failed to record change")
+	}
+	return nil
+}
+
+// queueTaskEvent adds a task change to the outbox of the transaction making it
+func queueTaskEvent(tx repository.Store, eventType string, task *models.Task) error {
+	if err := tx.Outbox().Record(eventType, task.UserID, task.NoteID, task); err != nil {
+		log.Error().Ctx(tx.Context()).Err(err).Msg("Failed to record outbox event")
+		return newAPIError(fiber.StatusInternalServerError, "Failed to record change")
+	}
+	return nil
+}
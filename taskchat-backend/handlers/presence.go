@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"taskchat/presence"
+	"taskchat/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// type of schema for a presence heartbeat
+type PresenceRequest struct {
+	SessionID string `json:"session_id"`
+	Status    string `json:"status,omitempty"`
+}
+
+// list who is viewing a note right now
+func (h *Handler) GetPresence(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	noteID, err := h.presenceNote(c, userID)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"viewers": h.presence.Viewers(noteID), "timeout_seconds": int(presence.Timeout.Seconds())})
+}
+
+// record that a session is viewing a note, clients repeat it well within the timeout
+func (h *Handler) Heartbeat(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	//parse the request body
+	var req PresenceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.BadRequest(c, "Invalid req body ")
+	}
+
+	if req.SessionID == "" || len(req.SessionID) > 64 {
+		return utils.BadRequest(c, "session_id is required and must be under 64 characters")
+	}
+
+	status := presence.Status(req.Status)
+	if status == "" {
+		status = presence.Online
+	}
+	if status != presence.Online && status != presence.Away {
+		return utils.BadRequest(c, "Status must be online or away")
+	}
+
+	// a session that was checked a moment ago is not checked on every heartbeat
+	noteID, err := parseNoteID(c)
+	if err != nil {
+		return sendError(c, err)
+	}
+	verified := false
+	if !h.presence.Verified(noteID, userID, req.SessionID) {
+		if _, err := h.presenceNote(c, userID); err != nil {
+			return sendError(c, err)
+		}
+		verified = true
+	}
+
+	h.presence.Heartbeat(noteID, userID, req.SessionID, status, verified)
+
+	// return response
+	return utils.Success(c, fiber.Map{"viewers": h.presence.Viewers(noteID), "timeout_seconds": int(presence.Timeout.Seconds())})
+}
+
+// stop viewing a note without waiting for the heartbeat to time out
+func (h *Handler) LeaveNote(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	sessionID := c.Query("session_id")
+	if sessionID == "" {
+		return utils.BadRequest(c, "session_id is required")
+	}
+
+	noteID, err := h.presenceNote(c, userID)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	h.presence.Leave(noteID, userID, sessionID)
+
+	// return response
+	return utils.Success(c, fiber.Map{"message": "Left note"})
+}
+
+// presenceNote parses the note id and checks the user owns it
+func (h *Handler) presenceNote(c *fiber.Ctx, userID uuid.UUID) (uuid.UUID, error) {
+	noteID, err := parseNoteID(c)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if _, err := h.storeFor(c).Notes().Find(userID, noteID); err != nil {
+		return uuid.Nil, newAPIError(fiber.StatusNotFound, "Note not found")
+	}
+	return noteID, nil
+}
+
+func parseNoteID(c *fiber.Ctx) (uuid.UUID, error) {
+	noteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return uuid.Nil, newAPIError(fiber.StatusBadRequest, "Invalid note id")
+	}
+	return noteID, nil
+}
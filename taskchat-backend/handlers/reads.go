@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"taskchat/models"
+	"taskchat/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// NoteWithUnread is a note as listed in GetNotes, with how much changed since
+// the user last marked it read. the note has no json tags, so neither do
+// these fields to keep every key in the same style
+type NoteWithUnread struct {
+	models.Note
+	LastReadAt  *time.Time
+	UnreadTasks int64
+}
+
+// mark a note as read up to now
+func (h *Handler) MarkNoteRead(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get the note id from params
+	noteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid note id")
+	}
+
+	note, err := h.storeFor(c).Notes().Find(userID, noteID)
+	if err != nil {
+		return utils.NotFound(c, "Note not found")
+	}
+
+	read := models.NoteRead{UserID: userID, NoteID: noteID, LastReadAt: time.Now()}
+	if err := h.storeFor(c).Reads().Save(&read); err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to mark note read")
+		return utils.InternalError(c, "Failed to mark note read")
+	}
+
+	// return response
+	return utils.Success(c, NoteWithUnread{Note: *note, LastReadAt: &read.LastReadAt})
+}
+
+// unreadCounts adds the read pointer of every note and the number of tasks
+// created, changed or deleted after it. A note that was never read counts
+// every task that has activity
+func (h *Handler) unreadCounts(c *fiber.Ctx, userID uuid.UUID, notes []models.Note) ([]NoteWithUnread, error) {
+	result := make([]NoteWithUnread, len(notes))
+	if len(notes) == 0 {
+		return result, nil
+	}
+
+	noteIDs := make([]uuid.UUID, len(notes))
+	for i := range notes {
+		noteIDs[i] = notes[i].ID
+	}
+
+	reads, err := h.storeFor(c).Reads().ListByNotes(userID, noteIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	unread, err := h.storeFor(c).Reads().CountUnreadTasks(userID, noteIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	lastRead := make(map[uuid.UUID]time.Time, len(reads))
+	for _, read := range reads {
+		lastRead[read.NoteID] = read.LastReadAt
+	}
+
+	for i, note := range notes {
+		result[i] = NoteWithUnread{Note: note, UnreadTasks: unread[note.ID]}
+		if readAt, ok := lastRead[note.ID]; ok {
+			result[i].LastReadAt = &readAt
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"taskchat/events"
+	"taskchat/models"
+	"taskchat/repository"
+	"taskchat/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	entityNote = "note"
+	entityTask = "task"
+)
+
+// the editable fields of a note as stored in a revision snapshot
+func noteSnapshot(note *models.Note) models.JSON {
+	return models.JSON{"title": note.Title}
+}
+
+// the editable fields of a task as stored in a revision snapshot
+func taskSnapshot(task *models.Task) models.JSON {
+	return models.JSON{
+		"note_id":  task.NoteID.String(),
+		"title":    task.Title,
+		"status":   task.Status,
+		"priority": task.Priority,
+	}
+}
+
+// diffSnapshots lists the fields that differ between two snapshots
+func diffSnapshots(before, after models.JSON) models.JSON {
+	changes := models.JSON{}
+	for field, to := range after {
+		from, ok := before[field]
+		if !ok || from != to {
+			changes[field] = map[string]interface{}{"from": from, "to": to}
+		}
+	}
+	for field, from := range before {
+		if _, ok := after[field]; !ok {
+			changes[field] = map[string]interface{}{"from": from, "to": nil}
+		}
+	}
+	return changes
+}
+
+// recordRevision appends a revision row for a change made by userID
+func recordRevision(store repository.Store, userID uuid.UUID, entityType string, entityID uuid.UUID, action models.RevisionAction, before, after models.JSON) error {
+	revision := models.Revision{
+		ID:         uuid.New(),
+		EntityType: entityType,
+		EntityID:   entityID,
+		UserID:     userID,
+		Action:     string(action),
+		Changes:    diffSnapshots(before, after),
+		Snapshot:   after,
+	}
+
+	if err := store.Revisions().Create(&revision); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to record revision")
+		return newAPIError(fiber.StatusInternalServerError, "Failed to record history")
+	}
+	return nil
+}
+
+// get the revision history of a note
+func (h *Handler) GetNoteHistory(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get the note id from params
+	noteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid note id")
+	}
+
+	// history stays readable while the note is in the trash
+	if _, err := h.storeFor(c).Notes().FindAny(userID, noteID); err != nil {
+		return utils.NotFound(c, "Note not found")
+	}
+
+	return h.sendHistory(c, entityNote, noteID)
+}
+
+// get the revision history of a task
+func (h *Handler) GetTaskHistory(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get task id from params
+	taskID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid task id")
+	}
+
+	if _, err := h.storeFor(c).Tasks().FindAny(userID, taskID); err != nil {
+		return utils.NotFound(c, "Task not found")
+	}
+
+	return h.sendHistory(c, entityTask, taskID)
+}
+
+func (h *Handler) sendHistory(c *fiber.Ctx, entityType string, entityID uuid.UUID) error {
+	revisions, err := h.storeFor(c).Revisions().ListByEntity(entityType, entityID)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch revisions")
+		return utils.InternalError(c, "Failed to fetch history")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"revisions": revisions})
+}
+
+// put a note back to how it looked after a previous revision
+func (h *Handler) RevertNote(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get the note id from params
+	noteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid note id")
+	}
+
+	revisionID, err := uuid.Parse(c.Params("revision_id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid revision id")
+	}
+
+	var note models.Note
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		found, err := tx.Notes().Find(userID, noteID)
+		if err != nil {
+			return newAPIError(fiber.StatusNotFound, "Note not found")
+		}
+		note = *found
+
+		revision, err := findRevision(tx, entityNote, noteID, revisionID)
+		if err != nil {
+			return err
+		}
+
+		before := noteSnapshot(&note)
+		if title, ok := revision.Snapshot["title"].(string); ok {
+			note.Title = title
+		}
+
+		if err := tx.Notes().Save(&note); err != nil {
+			log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to revert note")
+			return err
+		}
+
+		if err := recordRevision(tx, userID, entityNote, note.ID, models.RevisionReverted, before, noteSnapshot(&note)); err != nil {
+			return err
+		}
+
+		if err := recordNoteRename(tx, userID, before, &note); err != nil {
+			return err
+		}
+
+		return queueNoteEvent(tx, events.NoteUpdated, &note)
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, note)
+}
+
+// put a task back to how it looked after a previous revision
+func (h *Handler) RevertTask(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get task id from params
+	taskID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid task id")
+	}
+
+	revisionID, err := uuid.Parse(c.Params("revision_id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid revision id")
+	}
+
+	var task models.Task
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		found, err := tx.Tasks().Find(userID, taskID)
+		if err != nil {
+			return newAPIError(fiber.StatusNotFound, "Task not found")
+		}
+		task = *found
+
+		revision, err := findRevision(tx, entityTask, taskID, revisionID)
+		if err != nil {
+			return err
+		}
+
+		// the task stays in its current note, only the editable fields are reverted
+		before := taskSnapshot(&task)
+		if title, ok := revision.Snapshot["title"].(string); ok {
+			task.Title = title
+		}
+		if status, ok := revision.Snapshot["status"].(string); ok {
+			task.Status = status
+		}
+		if priority, ok := revision.Snapshot["priority"].(string); ok {
+			task.Priority = priority
+		}
+
+		if err := tx.Tasks().Save(&task); err != nil {
+			log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to revert task")
+			return err
+		}
+
+		if err := recordRevision(tx, userID, entityTask, task.ID, models.RevisionReverted, before, taskSnapshot(&task)); err != nil {
+			return err
+		}
+
+		if err := recordTaskActivity(tx, userID, before, &task); err != nil {
+			return err
+		}
+
+		return queueTaskEvent(tx, events.TaskUpdated, &task)
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, task)
+}
+
+func findRevision(store repository.Store, entityType string, entityID, revisionID uuid.UUID) (*models.Revision, error) {
+	revision, err := store.Revisions().Find(entityType, entityID, revisionID)
+	if err != nil {
+		return nil, newAPIError(fiber.StatusNotFound, "Revision not found")
+	}
+	return revision, nil
+}
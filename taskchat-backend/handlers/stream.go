@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"taskchat/events"
+	"taskchat/metrics"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// comment line sent while idle so proxies keep the connection open
+const sseHeartbeat = 25 * time.Second
+
+// stream note and task changes to the client as server sent events
+func (h *Handler) StreamEvents(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// browsers send Last-Event-ID on reconnect, other clients can pass it as a query
+	lastEventID := c.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	// the writer outlives the handler, it keeps to the broker it subscribed on
+	hub := h.hub
+	sub, replay, reset := hub.Subscribe(userID, lastEventID)
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer hub.Unsubscribe(sub)
+
+		metrics.EventStreams.Inc()
+		defer metrics.EventStreams.Dec()
+
+		fmt.Fprint(w, "retry: 3000\n\n")
+
+		// the missed events are gone, tell the client to reload instead
+		if reset {
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+		for _, event := range replay {
+			writeSSE(w, event)
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case event, ok := <-sub.C:
+				if !ok {
+					// dropped for falling behind, the client reconnects and resumes
+					return
+				}
+				writeSSE(w, event)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case <-hub.Done():
+				// the client reconnects after the retry delay, to another instance if there is one
+				reason, _ := json.Marshal(fiber.Map{"reason": hub.CloseReason()})
+				fmt.Fprintf(w, "event: close\ndata: %s\n\n", reason)
+				w.Flush()
+				return
+			}
+
+			// a failed flush means the client has gone away
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+func writeSSE(w *bufio.Writer, event events.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode event")
+		return
+	}
+	fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+}
@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"strings"
-	"taskchat/database"
+	"taskchat/events"
+	"taskchat/metrics"
 	"taskchat/models"
+	"taskchat/repository"
 	"taskchat/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -21,7 +23,7 @@ type TaskRequest struct {
 var taskSanitizer = bluemonday.UGCPolicy()
 
 // get priorites tasks
-func GetPriorities(c *fiber.Ctx) error {
+func (h *Handler) GetPriorities(c *fiber.Ctx) error {
 
 	//get the userID from the JWT
 	userID, err := getUserID(c)
@@ -30,9 +32,9 @@ func GetPriorities(c *fiber.Ctx) error {
 	}
 
 	// get all the priority tasks
-	var tasks []models.Task
-	if err := database.DB.Where("user_id=? AND priority =?", userID, "high").Find(&tasks).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to fetch priority tasks")
+	tasks, err := h.storeFor(c).Tasks().ListByPriority(userID, "high")
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch priority tasks")
 		return utils.InternalError(c, "Failed to fetch priorities tasks")
 	}
 
@@ -42,7 +44,7 @@ func GetPriorities(c *fiber.Ctx) error {
 }
 
 // get all tasks function
-func GetTasks(c *fiber.Ctx) error {
+func (h *Handler) GetTasks(c *fiber.Ctx) error {
 
 	//get the userID from the JWT
 	userID, err := getUserID(c)
@@ -57,16 +59,15 @@ func GetTasks(c *fiber.Ctx) error {
 	}
 
 	// check if note exists
-	var note models.Note
-	if err := database.DB.Where("id=? AND user_id=?", noteID, userID).First(&note).Error; err != nil {
-		log.Error().Err(err).Msg("Note not found")
+	if _, err := h.storeFor(c).Notes().Find(userID, noteID); err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Note not found")
 		return utils.NotFound(c, "Note not found")
 	}
 
 	// fetch all the task for that particular note
-	var tasks []models.Task
-	if err := database.DB.Where("note_id=? AND user_id=?", noteID, userID).Find(&tasks).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to fetch notes")
+	tasks, err := h.storeFor(c).Tasks().ListByNote(userID, noteID)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch notes")
 		return utils.InternalError(c, "Failed to fetch tasks")
 	}
 
@@ -75,7 +76,7 @@ func GetTasks(c *fiber.Ctx) error {
 }
 
 // create tasks function
-func CreateTask(c *fiber.Ctx) error {
+func (h *Handler) CreateTask(c *fiber.Ctx) error {
 
 	//get the userID from the JWT
 	userID, err := getUserID(c)
@@ -89,27 +90,104 @@ func CreateTask(c *fiber.Ctx) error {
 		return utils.BadRequest(c, "Invalid note id")
 	}
 
-	// check if note exists
-	var note models.Note
-	if err := database.DB.Where("id=? AND user_id=?", noteID, userID).First(&note).Error; err != nil {
-		log.Error().Err(err).Msg("Note not found")
-		return utils.NotFound(c, "Note not found")
+	//parse req body
+	var req TaskRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.BadRequest(c, "Invalid req body ")
 	}
 
-	//parse req body
+	var task *models.Task
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		task, err = createTask(tx, userID, noteID, req)
+		return err
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+	metrics.TasksCreated.Inc()
+
+	// return response
+	return utils.Created(c, task)
+}
+
+// update tasks function
+func (h *Handler) UpdateTask(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get task id from params
+	taskID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid task id")
+	}
+
+	// parse request body
 	var req TaskRequest
 	if err := c.BodyParser(&req); err != nil {
 		return utils.BadRequest(c, "Invalid req body ")
 	}
 
+	var task *models.Task
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		task, err = updateTask(tx, userID, taskID, req)
+		return err
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, task)
+}
+
+// delete tasks function
+func (h *Handler) DeleteTask(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get task id from params
+	taskID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid task id")
+	}
+
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		_, err := deleteTask(tx, userID, taskID)
+		return err
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"message": "Task deleted successfully"})
+}
+
+// createTask validates the request and adds a task to a note owned by the user
+func createTask(store repository.Store, userID, noteID uuid.UUID, req TaskRequest) (*models.Task, error) {
+
+	// check if note exists
+	if _, err := store.Notes().Find(userID, noteID); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Note not found")
+		return nil, newAPIError(fiber.StatusNotFound, "Note not found")
+	}
+
 	// sanitze the req
 	req.Title = taskSanitizer.Sanitize(strings.TrimSpace(req.Title))
 	if req.Title == "" || len(req.Title) > 255 {
-		return utils.BadRequest(c, "Title is required and must under 255 characters")
+		return nil, newAPIError(fiber.StatusBadRequest, "Title is required and must under 255 characters")
 	}
 
 	if req.Priority != "" && req.Priority != "high" {
-		return utils.BadRequest(c, "Priority must be high or empty")
+		return nil, newAPIError(fiber.StatusBadRequest, "Priority must be high or empty")
 	}
 
 	// create task
@@ -123,47 +201,42 @@ func CreateTask(c *fiber.Ctx) error {
 	}
 
 	// save to database
-	if err := database.DB.Create(&task).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to create task")
-		return utils.InternalError(c, "Failed to create task")
+	if err := store.Tasks().Create(&task); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to create task")
+		return nil, newAPIError(fiber.StatusInternalServerError, "Failed to create task")
 	}
 
-	// return response
-	return utils.Created(c, task)
-}
-
-// update tasks function
-func UpdateTask(c *fiber.Ctx) error {
-
-	//get the userID from the JWT
-	userID, err := getUserID(c)
-	if err != nil {
-		return err
+	if err := recordRevision(store, userID, entityTask, task.ID, models.RevisionCreated, nil, taskSnapshot(&task)); err != nil {
+		return nil, err
 	}
 
-	// get task id from params
-	taskID, err := uuid.Parse(c.Params("id"))
-	if err != nil {
-		return utils.BadRequest(c, "Invalid task id")
+	if err := recordActivity(store, userID, noteID, &task.ID, models.ActivityTaskCreated, models.JSON{"title": task.Title}); err != nil {
+		return nil, err
 	}
 
-	// parse request body
-	var req TaskRequest
-	if err := c.BodyParser(&req); err != nil {
-		return utils.BadRequest(c, "Invalid req body ")
+	if err := queueTaskEvent(store, events.TaskCreated, &task); err != nil {
+		return nil, err
 	}
 
+	return &task, nil
+}
+
+// updateTask changes the status and priority of a task owned by the user
+func updateTask(store repository.Store, userID, taskID uuid.UUID, req TaskRequest) (*models.Task, error) {
+
 	// find task
-	var task models.Task
-	if err := database.DB.Where("id=? AND user_id=?", taskID, userID).First(&task).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to update task")
-		return utils.NotFound(c, "Task not found")
+	task, err := store.Tasks().Find(userID, taskID)
+	if err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to update task")
+		return nil, newAPIError(fiber.StatusNotFound, "Task not found")
 	}
 
+	before := taskSnapshot(task)
+
 	// update status
 	if req.Status != "" {
 		if req.Status != string(models.StatusPending) && req.Status != string(models.StatusCompleted) {
-			return utils.BadRequest(c, "Invalid status")
+			return nil, newAPIError(fiber.StatusBadRequest, "Invalid status")
 		}
 		task.Status = req.Status
 	}
@@ -171,7 +244,7 @@ func UpdateTask(c *fiber.Ctx) error {
 	// update priority
 	if req.Priority != "" {
 		if req.Priority != "" && req.Priority != "high" {
-			return utils.BadRequest(c, "Invalid priority")
+			return nil, newAPIError(fiber.StatusBadRequest, "Invalid priority")
 		}
 		task.Priority = req.Priority
 	}
@@ -181,43 +254,54 @@ func UpdateTask(c *fiber.Ctx) error {
 	}
 
 	// save updated task
-	if err := database.DB.Save(&task).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to update task")
-		return utils.InternalError(c, "Failed to update task")
+	if err := store.Tasks().Save(task); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to update task")
+		return nil, newAPIError(fiber.StatusInternalServerError, "Failed to update task")
 	}
 
-	// return response
-	return utils.Success(c, task)
+	if err := recordRevision(store, userID, entityTask, task.ID, models.RevisionUpdated, before, taskSnapshot(task)); err != nil {
+		return nil, err
+	}
+
+	if err := recordTaskActivity(store, userID, before, task); err != nil {
+		return nil, err
+	}
+
+	if err := queueTaskEvent(store, events.TaskUpdated, task); err != nil {
+		return nil, err
+	}
+
+	return task, nil
 }
 
-// delete tasks function
-func DeleteTask(c *fiber.Ctx) error {
+// deleteTask moves a task owned by the user to the trash
+func deleteTask(store repository.Store, userID, taskID uuid.UUID) (*models.Task, error) {
 
-	//get the userID from the JWT
-	userID, err := getUserID(c)
+	// find task
+	task, err := store.Tasks().Find(userID, taskID)
 	if err != nil {
-		return err
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to update task")
+		return nil, newAPIError(fiber.StatusNotFound, "Task not found")
 	}
 
-	// get task id from params
-	taskID, err := uuid.Parse(c.Params("id"))
-	if err != nil {
-		return utils.BadRequest(c, "Invalid task id")
+	// delete task
+	if err := store.Tasks().Trash(task); err != nil {
+		log.Error().Ctx(store.Context()).Err(err).Msg("Failed to delete task")
+		return nil, newAPIError(fiber.StatusInternalServerError, "Failed to delete task")
 	}
 
-	// find task
-	var task models.Task
-	if err := database.DB.Where("id=? AND user_id=?", taskID, userID).First(&task).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to update task")
-		return utils.NotFound(c, "Task not found")
+	snapshot := taskSnapshot(task)
+	if err := recordRevision(store, userID, entityTask, task.ID, models.RevisionDeleted, snapshot, snapshot); err != nil {
+		return nil, err
 	}
 
-	// delete task
-	if err := database.DB.Delete(&task).Error; err != nil {
-		log.Error().Err(err).Msg("Failed to delete task")
-		return utils.InternalError(c, "Failed to delete task")
+	if err := recordActivity(store, userID, task.NoteID, &task.ID, models.ActivityTaskDeleted, models.JSON{"title": task.Title}); err != nil {
+		return nil, err
 	}
 
-	// return response
-	return utils.Success(c, fiber.Map{"message": "Task deleted successfully"})
+	if err := queueTaskEvent(store, events.TaskDeleted, task); err != nil {
+		return nil, err
+	}
+
+	return task, nil
 }
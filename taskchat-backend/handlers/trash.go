@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"errors"
+	"taskchat/events"
+	"taskchat/models"
+	"taskchat/repository"
+	"taskchat/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// trashTimestamp is truncated to what postgres stores so it can be compared
+// against the deleted_at column when restoring
+func trashTimestamp() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// list everything in the trash
+func (h *Handler) GetTrash(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// trashed notes, their tasks come back with them so they are not listed
+	notes, err := h.storeFor(c).Notes().ListTrashed(userID)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch trashed notes")
+		return utils.InternalError(c, "Failed to fetch trash")
+	}
+
+	// tasks trashed on their own from notes that still exist
+	tasks, err := h.storeFor(c).Tasks().ListTrashed(userID)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch trashed tasks")
+		return utils.InternalError(c, "Failed to fetch trash")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"notes": notes, "tasks": tasks})
+}
+
+// restore a trashed note with the tasks that were trashed along with it
+func (h *Handler) RestoreNote(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get the note id from params
+	noteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid note id")
+	}
+
+	var note models.Note
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		found, err := tx.Notes().FindTrashed(userID, noteID)
+		if err != nil {
+			return newAPIError(fiber.StatusNotFound, "Note not found in trash")
+		}
+		note = *found
+
+		if err := tx.Notes().Restore(&note); err != nil {
+			log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to restore note")
+			return err
+		}
+
+		snapshot := noteSnapshot(&note)
+		if err := recordRevision(tx, userID, entityNote, note.ID, models.RevisionRestored, snapshot, snapshot); err != nil {
+			return err
+		}
+
+		if err := recordActivity(tx, userID, note.ID, nil, models.ActivityNoteRestored, models.JSON{"title": note.Title}); err != nil {
+			return err
+		}
+
+		return queueNoteEvent(tx, events.NoteRestored, &note)
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, note)
+}
+
+// restore a task that was trashed on its own
+func (h *Handler) RestoreTask(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get task id from params
+	taskID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid task id")
+	}
+
+	task, err := h.storeFor(c).Tasks().FindTrashed(userID, taskID)
+	if err != nil {
+		return utils.NotFound(c, "Task not found in trash")
+	}
+
+	// a task can only come back into a note that is not in the trash
+	if _, err := h.storeFor(c).Notes().Find(userID, task.NoteID); err != nil {
+		return utils.Conflict(c, "Note is in the trash, restore the note first")
+	}
+
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		if err := tx.Tasks().Restore(task); err != nil {
+			log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to restore task")
+			return err
+		}
+
+		snapshot := taskSnapshot(task)
+		if err := recordRevision(tx, userID, entityTask, task.ID, models.RevisionRestored, snapshot, snapshot); err != nil {
+			return err
+		}
+
+		if err := recordActivity(tx, userID, task.NoteID, &task.ID, models.ActivityTaskRestored, models.JSON{"title": task.Title}); err != nil {
+			return err
+		}
+
+		return queueTaskEvent(tx, events.TaskRestored, task)
+	})
+	if err != nil {
+		return utils.InternalError(c, "Failed to restore task")
+	}
+
+	// return response
+	return utils.Success(c, task)
+}
+
+// permanently delete a trashed note and all of its tasks
+func (h *Handler) PurgeNote(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get the note id from params
+	noteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid note id")
+	}
+
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		note, err := tx.Notes().FindTrashed(userID, noteID)
+		if err != nil {
+			return newAPIError(fiber.StatusNotFound, "Note not found in trash")
+		}
+
+		if err := tx.Notes().Purge(note); err != nil {
+			log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to purge note")
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"message": "Note permanently deleted"})
+}
+
+// permanently delete a trashed task
+func (h *Handler) PurgeTask(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	// get task id from params
+	taskID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utils.BadRequest(c, "Invalid task id")
+	}
+
+	if err := h.storeFor(c).Tasks().Purge(userID, taskID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			return utils.NotFound(c, "Task not found in trash")
+		}
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to purge task")
+		return utils.InternalError(c, "Failed to delete task")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"message": "Task permanently deleted"})
+}
+
+// permanently delete everything in the user's trash
+func (h *Handler) EmptyTrash(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.storeFor(c).Transaction(func(tx repository.Store) error {
+		return tx.Notes().EmptyTrash(userID)
+	})
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to empty trash")
+		return utils.InternalError(c, "Failed to empty trash")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"message": "Trash emptied"})
+}
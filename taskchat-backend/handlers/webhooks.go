@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"taskchat/events"
+	"taskchat/models"
+	"taskchat/utils"
+	"taskchat/webhooks"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// type of schema for creating and updating a webhook
+type WebhookRequest struct {
+	URL    string   `json:"url"`
+	NoteID string   `json:"note_id,omitempty"`
+	Events []string `json:"events,omitempty"`
+	Active *bool    `json:"active,omitempty"`
+}
+
+// list the user's webhooks
+func (h *Handler) GetWebhooks(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := h.storeFor(c).Webhooks().ListByUser(userID)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch webhooks")
+		return utils.InternalError(c, "Failed to fetch webhooks")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"webhooks": hooks})
+}
+
+// register a webhook for one note or, without a note id, for every note
+func (h *Handler) CreateWebhook(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	//parse the request body
+	var req WebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.BadRequest(c, "Invalid req body ")
+	}
+
+	hookURL, err := validateWebhookURL(req.URL)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	filter, err := webhookEventFilter(req.Events)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	webhook := models.Webhook{
+		ID:     uuid.New(),
+		UserID: userID,
+		URL:    hookURL,
+		Secret: webhooks.NewSecret(),
+		Events: filter,
+		Active: true,
+	}
+
+	if req.NoteID != "" {
+		noteID, err := uuid.Parse(req.NoteID)
+		if err != nil {
+			return utils.BadRequest(c, "Invalid note id")
+		}
+
+		if _, err := h.storeFor(c).Notes().Find(userID, noteID); err != nil {
+			return utils.NotFound(c, "Note not found")
+		}
+		webhook.NoteID = &noteID
+	}
+
+	if err := h.storeFor(c).Webhooks().Create(&webhook); err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to create webhook")
+		return utils.InternalError(c, "Failed to create webhook")
+	}
+
+	// the secret is only ever shown here
+	return utils.Created(c, fiber.Map{"webhook": webhook, "secret": webhook.Secret})
+}
+
+// change the url, event filter or active flag of a webhook
+func (h *Handler) UpdateWebhook(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.findWebhook(c, userID)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	//parse the request body
+	var req WebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return utils.BadRequest(c, "Invalid req body ")
+	}
+
+	if req.URL != "" {
+		if webhook.URL, err = validateWebhookURL(req.URL); err != nil {
+			return sendError(c, err)
+		}
+	}
+
+	if req.Events != nil {
+		if webhook.Events, err = webhookEventFilter(req.Events); err != nil {
+			return sendError(c, err)
+		}
+	}
+
+	if req.Active != nil {
+		webhook.Active = *req.Active
+		// turning it back on gives the endpoint a clean slate
+		if webhook.Active {
+			webhook.FailureCount = 0
+			webhook.DisabledAt = nil
+		}
+	}
+
+	if err := h.storeFor(c).Webhooks().Save(webhook); err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to update webhook")
+		return utils.InternalError(c, "Failed to update webhook")
+	}
+
+	// return response
+	return utils.Success(c, webhook)
+}
+
+// remove a webhook together with its delivery log
+func (h *Handler) DeleteWebhook(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.findWebhook(c, userID)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	if err := h.storeFor(c).Webhooks().Delete(webhook); err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to delete webhook")
+		return utils.InternalError(c, "Failed to delete webhook")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"message": "Webhook deleted successfully"})
+}
+
+// send a test event to the webhook and report how the endpoint answered
+func (h *Handler) TestWebhook(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.findWebhook(c, userID)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	if !webhook.Active {
+		return utils.Conflict(c, "Webhook is disabled")
+	}
+
+	delivery, err := h.webhooks.SendTest(c.UserContext(), webhook)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to send test webhook")
+		return utils.InternalError(c, "Failed to send test event")
+	}
+
+	// only the status code the endpoint answered with, 0 when it did not,
+	// so the endpoint cannot be used to probe the network behind the server
+	statusCode := 0
+	if n := len(delivery.DeliveryAttempts); n > 0 {
+		statusCode = delivery.DeliveryAttempts[n-1].StatusCode
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"delivery_id": delivery.ID, "status": delivery.Status, "status_code": statusCode})
+}
+
+// list the most recent deliveries of a webhook with every attempt made
+func (h *Handler) GetWebhookDeliveries(c *fiber.Ctx) error {
+
+	//get the userID from the JWT
+	userID, err := getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	webhook, err := h.findWebhook(c, userID)
+	if err != nil {
+		return sendError(c, err)
+	}
+
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 100 {
+		return utils.BadRequest(c, "limit must be between 1 and 100")
+	}
+
+	deliveries, err := h.storeFor(c).Webhooks().ListDeliveries(webhook.ID, limit)
+	if err != nil {
+		log.Error().Ctx(c.UserContext()).Err(err).Msg("Failed to fetch webhook deliveries")
+		return utils.InternalError(c, "Failed to fetch deliveries")
+	}
+
+	// return response
+	return utils.Success(c, fiber.Map{"deliveries": deliveries})
+}
+
+func (h *Handler) findWebhook(c *fiber.Ctx, userID uuid.UUID) (*models.Webhook, error) {
+	webhookID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, newAPIError(fiber.StatusBadRequest, "Invalid webhook id")
+	}
+
+	webhook, err := h.storeFor(c).Webhooks().Find(userID, webhookID)
+	if err != nil {
+		return nil, newAPIError(fiber.StatusNotFound, "Webhook not found")
+	}
+	return webhook, nil
+}
+
+// validateWebhookURL accepts absolute http and https urls. Where the url
+// points is checked when it is dialled, a name can resolve differently later
+func validateWebhookURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || len(raw) > 2048 {
+		return "", newAPIError(fiber.StatusBadRequest, "url must be an absolute http or https url")
+	}
+	return raw, nil
+}
+
+// webhookEventFilter turns the requested event types into the stored filter,
+// no events means every event
+func webhookEventFilter(requested []string) (string, error) {
+	if len(requested) == 0 {
+		return "*", nil
+	}
+
+	for _, eventType := range requested {
+		if eventType != "*" && !slices.Contains(events.Types, eventType) {
+			return "", newAPIError(fiber.StatusBadRequest, "Unknown event type "+eventType)
+		}
+	}
+
+	filter := slices.Clone(requested)
+	slices.Sort(filter)
+	return strings.Join(slices.Compact(filter), ","), nil
+}
@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userIDKey
+)
+
+// WithRequestID returns ctx carrying the id of the request it belongs to
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID is the id of the request ctx belongs to, empty outside a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithUserID returns ctx carrying the authenticated user making the request
+func WithUserID(ctx context.Context, userID uuid.UUID) context.Context {
+	return context.WithValue(ctx, userIDKey, userID)
+}
+
+// ContextHook adds the request and user ids to log events written with the
+// context of a request, log.Error().Ctx(c.UserContext())
+type ContextHook struct{}
+
+func (ContextHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	ctx := e.GetCtx()
+	if id := RequestID(ctx); id != "" {
+		e.Str("request_id", id)
+	}
+	if userID, ok := ctx.Value(userIDKey).(uuid.UUID); ok {
+		e.Str("user_id", userID.String())
+	}
+}
@@ -0,0 +1,169 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+	"taskchat/config"
+	"taskchat/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// what replaces secrets in logged headers, queries and bodies
+const redacted = "[REDACTED]"
+
+// request bodies above this size are never logged
+const maxLoggedBody = 4 * 1024
+
+// ids from clients are kept when they are reasonable to put in a log line
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// header, query and json field names containing any of these are redacted
+var sensitiveNames = []string{"authorization", "cookie", "password", "secret", "token"}
+
+// Middleware gives every request an id and logs one line for it once it is
+// handled. The X-Request-ID header is kept when the client or a proxy sent
+// one, generated otherwise, and echoed in the response. Log events written
+// with c.UserContext() carry the id through ContextHook
+func Middleware(cfg config.LogConfig) fiber.Handler {
+	// checked by config.Validate
+	successLevel, _ := zerolog.ParseLevel(cfg.RequestLevel)
+
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		id := c.Get(fiber.HeaderXRequestID)
+		if !requestIDRegex.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Locals(utils.RequestIDKey, id)
+		c.Set(fiber.HeaderXRequestID, id)
+		c.SetUserContext(WithRequestID(c.UserContext(), id))
+
+		err := c.Next()
+
+		route, status := utils.RequestOutcome(c, err)
+		level := successLevel
+		switch {
+		case status >= fiber.StatusInternalServerError:
+			level = zerolog.ErrorLevel
+		case status >= fiber.StatusBadRequest:
+			level = zerolog.WarnLevel
+		}
+
+		event := log.WithLevel(level)
+		if !event.Enabled() {
+			return err
+		}
+
+		// the user context now also carries the user id when the request was authenticated
+		event = event.Ctx(c.UserContext()).
+			Str("method", c.Method()).
+			Str("path", c.Path()).
+			Str("route", route).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("ip", c.IP()).
+			Str("user_agent", c.Get(fiber.HeaderUserAgent))
+
+		// reading the body of a streamed response would run the stream
+		if !c.Response().IsBodyStream() {
+			event = event.Int("bytes_out", len(c.Response().Body()))
+		}
+		if query := redactedQuery(c); query != "" {
+			event = event.Str("query", query)
+		}
+		if err != nil {
+			event = event.Err(err)
+		}
+		if zerolog.GlobalLevel() <= zerolog.DebugLevel {
+			event = event.Interface("headers", redactedHeaders(c))
+		}
+		if zerolog.GlobalLevel() <= zerolog.TraceLevel {
+			if body := redactedBody(c); body != nil {
+				event = event.RawJSON("body", body)
+			}
+		}
+
+		event.Msg("Request")
+		return err
+	}
+}
+
+func sensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitiveNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedQuery is the query string with tokens masked, event streams send
+// their JWT as access_token
+func redactedQuery(c *fiber.Ctx) string {
+	values := url.Values{}
+	for key, value := range c.Queries() {
+		if sensitive(key) {
+			value = redacted
+		}
+		values.Set(key, value)
+	}
+	return values.Encode()
+}
+
+func redactedHeaders(c *fiber.Ctx) map[string]string {
+	headers := map[string]string{}
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		if sensitive(string(key)) {
+			headers[string(key)] = redacted
+			return
+		}
+		headers[string(key)] = string(value)
+	})
+	return headers
+}
+
+// redactedBody is the json request body with passwords and tokens masked, nil
+// for bodies that are not json or too large to log
+func redactedBody(c *fiber.Ctx) []byte {
+	body := c.Body()
+	if len(body) == 0 || len(body) > maxLoggedBody || !strings.HasPrefix(c.Get(fiber.HeaderContentType), fiber.MIMEApplicationJSON) {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil
+	}
+	out, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if sensitive(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = redactValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
@@ -1,195 +1,232 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"taskchat/config"
 	"taskchat/database"
+	"taskchat/events"
 	"taskchat/handlers"
-	"taskchat/middleware"
+	"taskchat/logging"
+	"taskchat/metrics"
+	"taskchat/presence"
+	"taskchat/repository"
+	"taskchat/tracing"
+	"taskchat/utils"
+	"taskchat/webhooks"
+	"taskchat/workers"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/logger"
-	"github.com/joho/godotenv"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
 )
 
 func main() {
 
-	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env file")
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "":
+		serve(cfg)
+
+	// "taskchat migrate ..." manages the schema instead of serving
+	case "migrate":
+		runMigrate(cfg, args[1:])
+
+	// "taskchat config" shows the settings the server would run with
+	case "config":
+		printConfig(cfg)
+
+	default:
+		log.Fatalf("unknown command %q, expected migrate or config", command)
+	}
+}
+
+func serve(cfg config.Config) {
+
+	if err := cfg.Validate(); err != nil {
+		zlog.Fatal().Err(err).Msg("Invalid configuration")
+	}
+
+	configureLogging(cfg.Log)
+	utils.ConfigureJWT(cfg.JWT.Secret, cfg.JWT.TTL)
+
+	flushTraces, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+
+	db, err := database.InitDB(cfg.Database)
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("Failed to set up the database")
+	}
+
+	// queries made with a request's context become spans of its trace
+	if err := tracing.RegisterGORM(db); err != nil {
+		zlog.Fatal().Err(err).Msg("Failed to trace queries")
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		zlog.Fatal().Err(err).Msg("Failed to get the connection pool")
+	}
+	if err := metrics.RegisterDB(sqlDB, cfg.Database.Driver); err != nil {
+		zlog.Fatal().Err(err).Msg("Failed to export pool metrics")
 	}
 
-	database.InitDB()
+	// every handler works through the store instead of a global connection
+	store := repository.NewGormStore(db)
 
-	app := fiber.New()
+	app := fiber.New(fiberConfig(cfg.Server))
 
-	app.Use(logger.New())
+	// first so everything after it, the other middleware included, is inside the request span
+	app.Use(tracing.Middleware)
+	app.Use(logging.Middleware(cfg.Log))
+	app.Use(metrics.Middleware)
 
-	app.Get("/api/health", func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"status": "ok"})
+	if len(cfg.CORS.AllowOrigins) > 0 {
+		app.Use(cors.New(cors.Config{
+			AllowOrigins:  strings.Join(cfg.CORS.AllowOrigins, ","),
+			AllowHeaders:  "Authorization, Content-Type, Last-Event-ID, X-Request-ID",
+			ExposeHeaders: "X-Request-ID",
+		}))
+	}
+
+	if cfg.RateLimit.Requests > 0 {
+		app.Use("/api", limiter.New(limiter.Config{
+			Max:        cfg.RateLimit.Requests,
+			Expiration: cfg.RateLimit.Window,
+			LimitReached: func(c *fiber.Ctx) error {
+				return utils.Error(c, fiber.StatusTooManyRequests, "Too many requests, try again later")
+			},
+		}))
+	}
+
+	// share change events with the other instances through postgres
+	var bus events.Bus = events.NewMemoryBus()
+	if cfg.EventBus == "postgres" {
+		bus = events.NewPostgresBus(db, cfg.Database.URL)
+	}
+
+	// the one broker and tracker everything publishes to and streams from
+	hub := events.NewBroker(bus, events.DefaultHistorySize)
+	tracker := presence.NewTracker(bus, hub)
+
+	registerRoutes(app, cfg, store, hub, tracker)
+
+	srv := newServer(app, db, hub)
+	srv.bus = bus
+	srv.flushTraces = flushTraces
+
+	// forget note viewers whose heartbeats stopped
+	srv.goWorker(tracker.Run)
+
+	// empty the trash of anything older than the retention period
+	srv.goWorker(func(ctx context.Context) {
+		workers.RunTrashPurger(ctx, store, cfg.TrashRetention(), time.Hour)
 	})
 
-	app.Post("/api/register", handlers.Register)
-	app.Post("/api/login", handlers.Login)
+	// publish the change events written by the handlers
+	srv.goWorker(func(ctx context.Context) {
+		workers.RunOutboxDispatcher(ctx, store, hub, 500*time.Millisecond)
+	})
 
-	app.Get("/api/protected", middleware.AuthMiddleware, func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"message": "Protected route accessed"})
+	// send queued webhook deliveries and their retries
+	srv.goWorker(func(ctx context.Context) {
+		workers.RunWebhookDispatcher(ctx, webhooks.NewService(store, cfg.Webhooks.AllowPrivateTargets), 5*time.Second)
 	})
 
-	notes := app.Group("/api/notes", middleware.AuthMiddleware)
-	notes.Get("/", handlers.GetNotes)
-	notes.Post("/", handlers.CreateNote)
-	notes.Put("/:id", handlers.UpdateNote)
-	notes.Delete("/:id", handlers.DeleteNote)
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listenErr := make(chan error, 1)
+	zlog.Info().Str("port", cfg.Server.Port).Msg("Listening")
+	go func() {
+		listenErr <- app.Listen(":" + cfg.Server.Port)
+	}()
 
-	tasks := app.Group("/api", middleware.AuthMiddleware)
-	tasks.Get("/notes/:note_id/tasks", handlers.GetTasks)
-	tasks.Post("/notes/:note_id/tasks", handlers.CreateTask)
-	tasks.Put("/tasks/:id", handlers.UpdateTask)
-	tasks.Delete("/tasks/:id", handlers.DeleteTask)
+	select {
+	case err := <-listenErr:
+		zlog.Fatal().Err(err).Msg("Error starting server")
+	case <-signals.Done():
+	}
 
-	app.Get("/api/priorities", middleware.AuthMiddleware, handlers.GetPriorities)
+	// a second signal kills the process instead of waiting for the deadline
+	stop()
+	zlog.Info().Dur("timeout", cfg.Server.ShutdownTimeout).Msg("Shutting down")
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8081"
+	if err := srv.shutdown(cfg.Server.ShutdownTimeout); err != nil {
+		zlog.Fatal().Err(err).Msg("Shutdown did not complete cleanly")
 	}
+	zlog.Info().Msg("Shutdown complete")
+}
 
-	err := app.Listen(":" + port)
-	if err != nil {
-		log.Fatal("Error starting server")
+// fiberConfig is how the server is set up to accept requests
+func fiberConfig(cfg config.ServerConfig) fiber.Config {
+	return fiber.Config{
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+		BodyLimit:    cfg.BodyLimit,
+		// c.IP() reads the proxy header only on connections from a trusted
+		// proxy, and only the first valid address in it
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.TrustedProxies,
+		EnableIPValidation:      true,
+		// errors returned by handlers get the same json body as the ones they write
+		ErrorHandler: handlers.ErrorHandler,
+		// "Listening" is logged as json like everything else
+		DisableStartupMessage: true,
+	}
+}
+
+// configureLogging applies the level and format to the zerolog logger the
+// handlers and workers write to
+func configureLogging(cfg config.LogConfig) {
+	level, _ := zerolog.ParseLevel(cfg.Level)
+	zerolog.SetGlobalLevel(level)
+
+	if cfg.Format == "console" {
+		zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
+
+	// events logged with a request's context carry its request, user and trace ids
+	zlog.Logger = zlog.Logger.Hook(logging.ContextHook{}, tracing.LogHook{})
 }
 
-// package main
-
-// import (
-// 	"fmt"
-// 	"log"
-// 	"os"
-// 	"os/signal"
-// 	"syscall"
-// 	"taskchat/config"
-// 	"taskchat/database"
-// 	"taskchat/handlers"
-// 	"taskchat/middleware"
-
-// 	"github.com/gofiber/fiber/v2"
-// 	"github.com/gofiber/fiber/v2/middleware/cors"
-// 	"github.com/gofiber/fiber/v2/middleware/limiter"
-// 	"github.com/gofiber/fiber/v2/middleware/logger"
-// 	"github.com/joho/godotenv"
-// 	"github.com/rs/zerolog"
-// 	zlog "github.com/rs/zerolog/log"
-// )
-
-// // App holds the Fiber app and database connection
-// type App struct {
-// 	Fiber *fiber.App // The web server
-// 	DB    *database.DB // The database connection
-// }
-
-// // NewApp sets up the app with database and routes
-// func NewApp() (*App, error) {
-// 	// Load environment variables from .env file
-// 	if err := godotenv.Load(); err != nil {
-// 		return nil, fmt.Errorf("cannot load .env file: %v", err)
-// 	}
-
-// 	// Check if required environment variables are set
-// 	if err := config.ValidateEnvVars(); err != nil {
-// 		return nil, fmt.Errorf("missing environment variables: %v", err)
-// 	}
-
-// 	// Set up logger to print clear logs
-// 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-// 	zlog.Logger = zlog.Output(zerolog.ConsoleWriter{Out: os.Stderr})
-
-// 	// Connect to the database
-// 	db, err := database.InitDB()
-// 	if err != nil {
-// 		return nil, fmt.Errorf("cannot connect to database: %v", err)
-// 	}
-
-// 	// Create a new Fiber app
-// 	fiberApp := fiber.New(fiber.Config{
-// 		ErrorHandler: handlers.ErrorHandler, // Custom error handling
-// 	})
-
-// 	// Add middleware for logging, CORS, and rate limiting
-// 	fiberApp.Use(logger.New()) // Logs all requests
-// 	fiberApp.Use(cors.New(cors.Config{
-// 		AllowOrigins: os.Getenv("CORS_ALLOW_ORIGINS"), // Allow specific frontend URLs
-// 		AllowMethods: "GET,POST,PUT,DELETE",          // Allowed HTTP methods
-// 	}))
-// 	fiberApp.Use(limiter.New(limiter.Config{
-// 		Max:        5,           // Allow 5 requests per minute
-// 		Expiration: 60,          // Per minute
-// 		KeyGenerator: func(c *fiber.Ctx) string {
-// 			return c.IP() // Limit by client IP
-// 		},
-// 		LimitReached: func(c *fiber.Ctx) error {
-// 			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
-// 				"success": false,
-// 				"error":   "Too many requests, try again later",
-// 			})
-// 		},
-// 	}))
-
-// 	// Define API routes
-// 	fiberApp.Get("/api/health", func(c *fiber.Ctx) error {
-// 		return c.JSON(fiber.Map{"status": "ok"}) // Health check endpoint
-// 	})
-// 	fiberApp.Post("/api/register", handlers.Register(db))
-// 	fiberApp.Post("/api/login", handlers.Login(db))
-
-// 	// Protected routes require JWT authentication
-// 	protected := fiberApp.Group("/api", middleware.AuthMiddleware)
-// 	protected.Get("/protected", func(c *fiber.Ctx) error {
-// 		return c.JSON(fiber.Map{"message": "You accessed a protected route!"})
-// 	})
-
-// 	// Note routes
-// 	notes := protected.Group("/notes")
-// 	notes.Get("/", handlers.GetNotes(db))
-// 	notes.Post("/", handlers.CreateNote(db))
-// 	notes.Put("/:id", handlers.UpdateNote(db))
-// 	notes.Delete("/:id", handlers.DeleteNote(db))
-
-// 	// Task routes
-// 	tasks := protected.Group("/notes/:note_id/tasks")
-// 	tasks.Get("/", handlers.GetTasks(db))
-// 	tasks.Post("/", handlers.CreateTask(db))
-// 	tasks.Put("/tasks/:id", handlers.UpdateTask(db))
-// 	tasks.Delete("/tasks/:id", handlers.DeleteTask(db))
-
-// 	// Priority tasks route
-// 	protected.Get("/priorities", handlers.GetPriorities(db))
-
-// 	return &App{Fiber: fiberApp, DB: db}, nil
-// }
-
-// func main() {
-// 	// Create the app
-// 	app, err := NewApp()
-// 	if err != nil {
-// 		log.Fatalf("Failed to start app: %v", err)
-// 	}
-
-// 	// Start the server in a separate goroutine
-// 	go func() {
-// 		port := config.LoadConfig().Port
-// 		if err := app.Fiber.Listen(":" + port); err != nil {
-// 			zlog.Fatal().Err(err).Msg("Cannot start server")
-// 		}
-// 	}()
-
-// 	// Wait for Ctrl+C to shut down gracefully
-// 	c := make(chan os.Signal, 1)
-// 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-// 	<-c
-// 	zlog.Info().Msg("Shutting down server...")
-// 	if err := app.Fiber.Shutdown(); err != nil {
-// 		zlog.Error().Err(err).Msg("Error shutting down server")
-// 	}
-// 	zlog.Info().Msg("Server stopped")
-// }
+// printConfig writes the effective configuration with secrets masked, and
+// fails when it would not be accepted by the server
+func printConfig(cfg config.Config) {
+	out, err := cfg.Redacted().YAML()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(out)
+
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
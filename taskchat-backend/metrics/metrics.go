@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"taskchat/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// prefix of every metric the app exports
+const namespace = "taskchat"
+
+// Registry holds the app's metrics next to the go runtime and process ones
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by method, route template and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// EventStreams is the number of clients connected to /api/events
+	EventStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "event_streams_active",
+		Help:      "Server sent event streams currently open.",
+	})
+
+	// Logins counts login attempts by result, success or failure
+	Logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts, by result.",
+	}, []string{"result"})
+
+	// NotesCreated counts notes committed through the API, batches included
+	NotesCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notes_created_total",
+		Help:      "Notes created.",
+	})
+
+	// TasksCreated counts tasks committed through the API, batches included
+	TasksCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tasks_created_total",
+		Help:      "Tasks created.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		EventStreams,
+		Logins,
+		NotesCreated,
+		TasksCreated,
+	)
+
+	// show both results from the start instead of only once each has happened
+	Logins.WithLabelValues("success")
+	Logins.WithLabelValues("failure")
+}
+
+// RegisterDB exports the pool stats of db, it is called once for the pool
+// the server runs on
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves everything in Registry in the Prometheus text format
+func Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
+
+// Middleware times every request and counts it under the route it matched,
+// so /api/notes/:id is one series however many notes there are
+func Middleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	route, status := utils.RequestOutcome(c, err)
+	labels := prometheus.Labels{"method": c.Method(), "route": route, "status": strconv.Itoa(status)}
+	httpRequests.With(labels).Inc()
+	httpDuration.With(labels).Observe(time.Since(start).Seconds())
+
+	return err
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"slices"
+	"taskchat/repository"
+	"taskchat/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// AdminMiddleware only lets through users whose email is in adminEmails, it
+// must run after AuthMiddleware
+func AdminMiddleware(users repository.UserRepository, adminEmails []string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, ok := c.Locals("user_id").(uuid.UUID)
+		if !ok {
+			return utils.Unauthorized(c, "Invalid token")
+		}
+
+		// look the user up so a deleted account loses access straight away
+		user, err := users.FindByID(userID)
+		if err != nil {
+			return utils.Unauthorized(c, "Invalid token")
+		}
+
+		if !slices.Contains(adminEmails, user.Email) {
+			return utils.Error(c, fiber.StatusForbidden, "Admin access required")
+		}
+
+		return c.Next()
+	}
+}
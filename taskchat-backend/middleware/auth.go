@@ -1,15 +1,15 @@
 package middleware
 
 import (
-	"log"
-	"os"
 	"strings"
+	"taskchat/logging"
 	"taskchat/utils"
 
 	"github.com/gofiber/fiber/v2"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
 )
 
 func AuthMiddleware(c *fiber.Ctx) error {
@@ -31,10 +31,10 @@ func AuthMiddleware(c *fiber.Ctx) error {
 			return nil, fiber.NewError(fiber.StatusUnauthorized, "Unexpected signing method")
 
 		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
+		return utils.JWTSecret(), nil
 	})
 	if err != nil {
-		log.Println("Failed to parse JWT", err)
+		log.Debug().Ctx(c.UserContext()).Err(err).Msg("Failed to parse JWT")
 		return utils.Unauthorized(c, "Invalid token")
 	}
 
@@ -52,9 +52,21 @@ func AuthMiddleware(c *fiber.Ctx) error {
 
 		// stroing the user id in the req body
 		c.Locals("user_id", userID)
+		c.SetUserContext(logging.WithUserID(c.UserContext(), userID))
 		return c.Next()
 	}
 
 	return utils.Unauthorized(c, "Invalid token")
 
 }
+
+// TokenFromQuery lets clients that cannot set headers, like the browser
+// EventSource, send the JWT in the access_token query parameter
+func TokenFromQuery(c *fiber.Ctx) error {
+	if c.Get("Authorization") == "" {
+		if token := c.Query("access_token"); token != "" {
+			c.Request().Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+	return c.Next()
+}
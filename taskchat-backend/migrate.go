@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"taskchat/config"
+	"taskchat/database"
+	"time"
+)
+
+// where "migrate create" writes new files, relative to the backend directory
+const migrationsDir = "database/migrations"
+
+const migrateUsage = `usage: taskchat migrate <command>
+
+  up            apply every pending migration
+  down [n]      revert the last n applied migrations, 1 by default
+  status        list migrations and when they were applied
+  create <name> write empty up and down files for a new migration`
+
+// runMigrate handles "taskchat migrate ..." and exits without serving
+func runMigrate(cfg config.Config, args []string) {
+	if len(args) == 0 {
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+
+	// creating files needs no database
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Println(migrateUsage)
+			os.Exit(2)
+		}
+		paths, err := database.CreateMigration(migrationsDir, args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, path := range paths {
+			fmt.Println("created", path)
+		}
+		return
+	}
+
+	db, err := database.Open(cfg.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := database.MigrateUp(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("applied %d migration(s)\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatal("down takes a positive number of migrations")
+			}
+		}
+		reverted, err := database.MigrateDown(db, steps)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("reverted %d migration(s)\n", reverted)
+
+	case "status":
+		states, err := database.MigrationStatus(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, state := range states {
+			applied := "pending"
+			if state.AppliedAt != nil {
+				applied = state.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d  %-30s  %s\n", state.Version, state.Name, applied)
+		}
+
+	default:
+		fmt.Println(migrateUsage)
+		os.Exit(2)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type User struct {
@@ -14,11 +15,12 @@ type User struct {
 }
 
 type Note struct {
-	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
-	Title     string    `gorm:"type:varchar(100);not null"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	ID        uuid.UUID      `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID      `gorm:"type:uuid;not null;index"`
+	Title     string         `gorm:"type:varchar(100);not null"`
+	CreatedAt time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
 type TaskStatus string
@@ -29,12 +31,151 @@ const (
 )
 
 type Task struct {
+	ID        uuid.UUID      `gorm:"type:uuid;primaryKey"`
+	NoteID    uuid.UUID      `gorm:"type:uuid;not null;index"`
+	UserID    uuid.UUID      `gorm:"type:uuid;not null;index"`
+	Title     string         `gorm:"type:varchar(255);not null"`
+	Status    string         `gorm:"type:varchar(100);not null;default:'pending'"`
+	Priority  string         `gorm:"type:varchar(100);not null;default:' '"`
+	CreatedAt time.Time      `gorm:"autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+type RevisionAction string
+
+const (
+	RevisionCreated  RevisionAction = "created"
+	RevisionUpdated  RevisionAction = "updated"
+	RevisionDeleted  RevisionAction = "deleted"
+	RevisionRestored RevisionAction = "restored"
+	RevisionReverted RevisionAction = "reverted"
+)
+
+// Revision is an immutable record of one change to a note or task. Changes
+// holds the fields that changed as {"field": {"from": ..., "to": ...}} and
+// Snapshot the editable fields after the change, which is what a revert restores
+type Revision struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	EntityType string    `gorm:"type:varchar(20);not null;index:idx_revisions_entity"`
+	EntityID   uuid.UUID `gorm:"type:uuid;not null;index:idx_revisions_entity"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index"`
+	Action     string    `gorm:"type:varchar(20);not null"`
+	Changes    JSON      `gorm:"type:jsonb"`
+	Snapshot   JSON      `gorm:"type:jsonb;not null"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"`
+}
+
+type ActivityType string
+
+const (
+	ActivityNoteCreated       ActivityType = "note.created"
+	ActivityNoteRenamed       ActivityType = "note.renamed"
+	ActivityNoteDeleted       ActivityType = "note.deleted"
+	ActivityNoteRestored      ActivityType = "note.restored"
+	ActivityTaskCreated       ActivityType = "task.created"
+	ActivityTaskCompleted     ActivityType = "task.completed"
+	ActivityTaskReopened      ActivityType = "task.reopened"
+	ActivityTaskReprioritized ActivityType = "task.reprioritized"
+	ActivityTaskDeleted       ActivityType = "task.deleted"
+	ActivityTaskRestored      ActivityType = "task.restored"
+)
+
+// Activity is a domain event shown in the note and user activity feeds
+type Activity struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index"`
+	NoteID    uuid.UUID  `gorm:"type:uuid;not null;index:idx_activities_note_created"`
+	TaskID    *uuid.UUID `gorm:"type:uuid"`
+	Type      string     `gorm:"type:varchar(50);not null"`
+	Data      JSON       `gorm:"type:jsonb"`
+	CreatedAt time.Time  `gorm:"autoCreateTime;index:idx_activities_note_created"`
+}
+
+// NoteRead is how far a user has caught up with a note
+type NoteRead struct {
+	UserID     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	NoteID     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	LastReadAt time.Time `gorm:"not null"`
+}
+
+// AuditLog is an append only record of a security relevant event. Each row
+// stores the hash of the row before it so any edit or deletion breaks the chain
+type AuditLog struct {
+	Seq       int64      `gorm:"primaryKey;autoIncrement:false"`
+	Event     string     `gorm:"type:varchar(50);not null;index"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"`
+	Email     string     `gorm:"type:varchar(255)"`
+	IP        string     `gorm:"type:varchar(64)"`
+	UserAgent string     `gorm:"type:varchar(512)"`
+	Success   bool       `gorm:"not null"`
+	Metadata  JSON       `gorm:"type:jsonb"`
+	CreatedAt time.Time  `gorm:"not null;index"`
+	PrevHash  string     `gorm:"type:varchar(64);not null"`
+	Hash      string     `gorm:"type:varchar(64);not null"`
+}
+
+// Webhook posts signed change events to a URL. A nil NoteID covers every
+// note the user owns, Events is a comma separated list of event types or *
+type Webhook struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	UserID       uuid.UUID  `gorm:"type:uuid;not null;index"`
+	NoteID       *uuid.UUID `gorm:"type:uuid;index"`
+	URL          string     `gorm:"type:varchar(2048);not null"`
+	Secret       string     `gorm:"type:varchar(128);not null" json:"-"`
+	Events       string     `gorm:"type:varchar(1024);not null;default:'*'"`
+	Active       bool       `gorm:"not null;default:true"`
+	FailureCount int        `gorm:"not null;default:0"`
+	DisabledAt   *time.Time
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+}
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one event queued for one webhook, retried until it
+// succeeds or runs out of attempts
+type WebhookDelivery struct {
 	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
-	NoteID    uuid.UUID `gorm:"type:uuid;not null;index"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
-	Title     string    `gorm:"type:varchar(255);not null"`
-	Status    string    `gorm:"type:varchar(100);not null;default:'pending'"`
-	Priority  string    `gorm:"type:varchar(100);not null;default:' '"`
-	CreatedAt time.Time `gorm:"autoCreateTime"`
-	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+	WebhookID uuid.UUID `gorm:"type:uuid;not null;index;uniqueIndex:idx_webhook_deliveries_event"`
+	// the outbox event delivered, empty for test deliveries
+	EventID       *uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_webhook_deliveries_event"`
+	EventType     string     `gorm:"type:varchar(50);not null"`
+	Payload       JSON       `gorm:"type:jsonb;not null"`
+	Status        string     `gorm:"type:varchar(20);not null;default:'pending';index:idx_webhook_deliveries_due"`
+	Attempts      int        `gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_webhook_deliveries_due"`
+	CreatedAt     time.Time  `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time  `gorm:"autoUpdateTime"`
+
+	DeliveryAttempts []WebhookAttempt `gorm:"foreignKey:DeliveryID"`
+}
+
+// WebhookAttempt records a single HTTP request made for a delivery
+type WebhookAttempt struct {
+	ID         uuid.UUID `gorm:"type:uuid;primaryKey"`
+	DeliveryID uuid.UUID `gorm:"type:uuid;not null;index"`
+	StatusCode int
+	Error      string    `gorm:"type:text"`
+	DurationMs int64     `gorm:"not null"`
+	CreatedAt  time.Time `gorm:"autoCreateTime"`
+}
+
+// OutboxEvent is a change event written in the same transaction as the
+// change itself and published afterwards by the outbox dispatcher. Its ID
+// travels with the event so consumers can drop duplicates
+type OutboxEvent struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey"`
+	Type        string     `gorm:"type:varchar(50);not null"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null"`
+	NoteID      uuid.UUID  `gorm:"type:uuid;not null"`
+	Payload     JSON       `gorm:"type:jsonb;not null"`
+	CreatedAt   time.Time  `gorm:"autoCreateTime;index"`
+	PublishedAt *time.Time `gorm:"index"`
 }
@@ -0,0 +1,56 @@
+package outbox
+
+import (
+	"context"
+	"taskchat/events"
+	"taskchat/repository"
+	"taskchat/webhooks"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Dispatch publishes up to limit unpublished events in the order they were
+// written and marks them published, returning how many it handled. An event
+// can be published again if the process dies before the batch is marked, so
+// consumers deduplicate on the event id. Events are written with
+// store.Outbox().Record in the transaction that makes the change
+func Dispatch(ctx context.Context, store repository.Store, hub *events.Broker, limit int) (int, error) {
+	var handled int
+
+	err := store.WithContext(ctx).Transaction(func(tx repository.Store) error {
+		pending, err := tx.Outbox().ListPending(limit)
+		if err != nil || len(pending) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(pending))
+		for i, event := range pending {
+			ids[i] = event.ID
+
+			// webhook deliveries are queued in this transaction, so they are
+			// written exactly once together with the published mark
+			if err := webhooks.Enqueue(tx, event.ID, event.UserID, event.NoteID, event.Type, event.Payload); err != nil {
+				return err
+			}
+
+			hub.Publish(events.Event{
+				EventID: event.ID.String(),
+				Type:    event.Type,
+				NoteID:  event.NoteID,
+				UserID:  event.UserID,
+				Data:    event.Payload,
+			})
+		}
+
+		handled = len(pending)
+		return tx.Outbox().MarkPublished(ids, time.Now())
+	})
+
+	return handled, err
+}
+
+// Cleanup deletes events that were published before the cutoff
+func Cleanup(store repository.Store, cutoff time.Time) (int64, error) {
+	return store.Outbox().DeletePublished(cutoff)
+}
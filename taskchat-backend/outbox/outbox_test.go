@@ -0,0 +1,92 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"taskchat/config"
+	"taskchat/database"
+	"taskchat/events"
+	"taskchat/models"
+	"taskchat/repository"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm/logger"
+)
+
+func TestDispatch(t *testing.T) {
+	db, err := database.Open(config.DatabaseConfig{Driver: database.DriverSQLite, URL: ":memory:"})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	store := repository.NewGormStore(db)
+
+	hub := events.NewBroker(events.NewMemoryBus(), events.DefaultHistorySize)
+	userID, noteID := uuid.New(), uuid.New()
+	sub, _, _ := hub.Subscribe(userID, "")
+	defer hub.Unsubscribe(sub)
+
+	hook := models.Webhook{ID: uuid.New(), UserID: userID, URL: "https://example.com/hook", Secret: "s", Events: events.NoteCreated, Active: true}
+	if err := store.Webhooks().Create(&hook); err != nil {
+		t.Fatalf("create webhook: %v", err)
+	}
+
+	for _, eventType := range []string{events.NoteCreated, events.TaskCreated} {
+		if err := store.Outbox().Record(eventType, userID, noteID, map[string]string{"type": eventType}); err != nil {
+			t.Fatalf("record %s: %v", eventType, err)
+		}
+	}
+	// an event recorded by a change that rolled back never happened
+	rollback := errors.New("rollback")
+	err = store.Transaction(func(tx repository.Store) error {
+		if err := tx.Outbox().Record(events.NoteDeleted, userID, noteID, map[string]string{"type": events.NoteDeleted}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("transaction returned %v", err)
+	}
+
+	// at most limit events go per batch, in the order they were written
+	var published []events.Event
+	for _, want := range []int{1, 1, 0} {
+		handled, err := Dispatch(context.Background(), store, hub, 1)
+		if err != nil || handled != want {
+			t.Fatalf("dispatch handled %d, expected %d: %v", handled, want, err)
+		}
+		for len(sub.C) > 0 {
+			published = append(published, <-sub.C)
+		}
+	}
+	if len(published) != 2 || published[0].Type != events.NoteCreated || published[1].Type != events.TaskCreated {
+		t.Fatalf("unexpected published events %+v", published)
+	}
+
+	var pending []models.OutboxEvent
+	if err := db.Where("published_at IS NULL").Find(&pending).Error; err != nil || len(pending) != 0 {
+		t.Fatalf("events left unpublished %+v: %v", pending, err)
+	}
+
+	// the webhook is queued for the one event it subscribes to, keyed by the event id
+	var deliveries []models.WebhookDelivery
+	if err := db.Find(&deliveries).Error; err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	if len(deliveries) != 1 || deliveries[0].EventType != events.NoteCreated || deliveries[0].EventID == nil || deliveries[0].EventID.String() != published[0].EventID {
+		t.Fatalf("unexpected deliveries %+v", deliveries)
+	}
+
+	// published events are only kept until the cleanup cutoff passes them
+	if deleted, err := Cleanup(store, time.Now().Add(-time.Hour)); err != nil || deleted != 0 {
+		t.Fatalf("cleanup before the cutoff deleted %d: %v", deleted, err)
+	}
+	if deleted, err := Cleanup(store, time.Now().Add(time.Minute)); err != nil || deleted != 2 {
+		t.Fatalf("cleanup deleted %d: %v", deleted, err)
+	}
+}
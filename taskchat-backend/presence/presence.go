@@ -0,0 +1,280 @@
+package presence
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"taskchat/events"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+type Status string
+
+const (
+	Online  Status = "online"
+	Away    Status = "away"
+	Offline Status = "offline"
+)
+
+const (
+	// a session that has not sent a heartbeat for this long is offline
+	Timeout = 60 * time.Second
+	// how often an unchanged session is announced to the other instances
+	// again, and how long a check of its access to the note is trusted
+	refreshInterval = Timeout / 3
+	// how often expired sessions are swept
+	sweepInterval = 10 * time.Second
+
+	// bus topic that carries presence changes
+	presenceTopic = "presence"
+)
+
+// Viewer is one session looking at a note
+type Viewer struct {
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID string    `json:"session_id"`
+	Status    Status    `json:"status"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type entry struct {
+	viewer Viewer
+	// the instance the session sends its heartbeats to
+	instance  string
+	announced time.Time
+	// when this instance last checked the user may view the note
+	verified time.Time
+}
+
+// busMessage is how a presence change travels between instances
+type busMessage struct {
+	Instance string    `json:"instance"`
+	NoteID   uuid.UUID `json:"note_id"`
+	Viewer   Viewer    `json:"viewer"`
+}
+
+// Tracker keeps who is viewing which note in memory. Heartbeats only reach
+// the instance serving them, changes and a periodic refresh are shared over
+// the bus so every instance sees the same viewers without touching the
+// database. Sessions of an instance that goes away expire after Timeout
+type Tracker struct {
+	bus events.Bus
+	// where changes are pushed to the open event streams
+	hub      *events.Broker
+	instance string
+	mu       sync.Mutex
+	notes    map[uuid.UUID]map[string]*entry
+}
+
+func NewTracker(bus events.Bus, hub *events.Broker) *Tracker {
+	id := make([]byte, 4)
+	_, _ = rand.Read(id)
+
+	t := &Tracker{
+		bus:      bus,
+		hub:      hub,
+		instance: hex.EncodeToString(id),
+		notes:    map[uuid.UUID]map[string]*entry{},
+	}
+	bus.Subscribe(presenceTopic, t.receive)
+
+	return t
+}
+
+func key(userID uuid.UUID, sessionID string) string {
+	return userID.String() + "/" + sessionID
+}
+
+// Verified reports whether this instance checked the session's access to the
+// note recently enough for the check to be skipped. Sessions announced by
+// other instances never are, and access that was revoked is noticed within
+// refreshInterval
+func (t *Tracker) Verified(noteID, userID uuid.UUID, sessionID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, ok := t.notes[noteID][key(userID, sessionID)]
+	return ok && e.instance == t.instance && time.Since(e.verified) < refreshInterval
+}
+
+// Heartbeat records that the session is viewing the note with the given
+// status, verified is whether the caller has just checked the user may
+func (t *Tracker) Heartbeat(noteID, userID uuid.UUID, sessionID string, status Status, verified bool) {
+	now := time.Now()
+
+	t.mu.Lock()
+	room := t.notes[noteID]
+	if room == nil {
+		room = map[string]*entry{}
+		t.notes[noteID] = room
+	}
+
+	e, ok := room[key(userID, sessionID)]
+	changed := !ok || e.viewer.Status != status
+	if !ok {
+		e = &entry{viewer: Viewer{UserID: userID, SessionID: sessionID}}
+		room[key(userID, sessionID)] = e
+	}
+	e.viewer.Status, e.viewer.LastSeen, e.instance = status, now, t.instance
+	if verified {
+		e.verified = now
+	}
+
+	announce := changed || now.Sub(e.announced) >= refreshInterval
+	if announce {
+		e.announced = now
+	}
+	viewer := e.viewer
+	t.mu.Unlock()
+
+	if announce {
+		t.announce(noteID, viewer)
+	}
+	if changed {
+		t.notify(noteID, viewer)
+	}
+}
+
+// Leave removes the session from the note straight away
+func (t *Tracker) Leave(noteID, userID uuid.UUID, sessionID string) {
+	t.mu.Lock()
+	e, ok := t.notes[noteID][key(userID, sessionID)]
+	if ok {
+		t.remove(noteID, key(userID, sessionID))
+	}
+	t.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	viewer := e.viewer
+	viewer.Status, viewer.LastSeen = Offline, time.Now()
+	t.announce(noteID, viewer)
+	t.notify(noteID, viewer)
+}
+
+// Viewers returns the sessions currently viewing the note
+func (t *Tracker) Viewers(noteID uuid.UUID) []Viewer {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	viewers := []Viewer{}
+	for _, e := range t.notes[noteID] {
+		if time.Since(e.viewer.LastSeen) < Timeout {
+			viewers = append(viewers, e.viewer)
+		}
+	}
+	return viewers
+}
+
+// Run sweeps expired sessions until ctx is cancelled
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.sweep()
+		}
+	}
+}
+
+// sweep drops sessions past the timeout. Only the instance that served a
+// session tells clients it went offline, so each change is pushed once
+func (t *Tracker) sweep() {
+	type expired struct {
+		noteID uuid.UUID
+		viewer Viewer
+	}
+	var gone []expired
+
+	t.mu.Lock()
+	for noteID, room := range t.notes {
+		for k, e := range room {
+			if time.Since(e.viewer.LastSeen) < Timeout {
+				continue
+			}
+			if e.instance == t.instance {
+				viewer := e.viewer
+				viewer.Status = Offline
+				gone = append(gone, expired{noteID, viewer})
+			}
+			t.remove(noteID, k)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, g := range gone {
+		t.notify(g.noteID, g.viewer)
+	}
+}
+
+// remove must be called with mu held
+func (t *Tracker) remove(noteID uuid.UUID, k string) {
+	delete(t.notes[noteID], k)
+	if len(t.notes[noteID]) == 0 {
+		delete(t.notes, noteID)
+	}
+}
+
+func (t *Tracker) announce(noteID uuid.UUID, viewer Viewer) {
+	payload, err := json.Marshal(busMessage{Instance: t.instance, NoteID: noteID, Viewer: viewer})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode presence")
+		return
+	}
+	if err := t.bus.Publish(context.Background(), presenceTopic, payload); err != nil {
+		log.Error().Err(err).Msg("Failed to publish presence")
+	}
+}
+
+// receive applies a change announced by another instance
+func (t *Tracker) receive(payload []byte) {
+	var message busMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		log.Error().Err(err).Msg("Invalid presence on bus")
+		return
+	}
+	if message.Instance == t.instance {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	k := key(message.Viewer.UserID, message.Viewer.SessionID)
+	if message.Viewer.Status == Offline {
+		t.remove(message.NoteID, k)
+		return
+	}
+
+	room := t.notes[message.NoteID]
+	if room == nil {
+		room = map[string]*entry{}
+		t.notes[message.NoteID] = room
+	}
+
+	// expire on this instance's clock, the sender's may be off
+	viewer := message.Viewer
+	viewer.LastSeen = time.Now()
+	room[k] = &entry{viewer: viewer, instance: message.Instance}
+}
+
+// notify pushes the change to the viewer's open event streams
+func (t *Tracker) notify(noteID uuid.UUID, viewer Viewer) {
+	t.hub.Publish(events.Event{
+		Type:   events.PresenceChanged,
+		NoteID: noteID,
+		UserID: viewer.UserID,
+		Data:   viewer,
+	})
+}
@@ -0,0 +1,46 @@
+package presence
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"taskchat/events"
+)
+
+func TestVerified(t *testing.T) {
+	bus := events.NewMemoryBus()
+	tracker := NewTracker(bus, events.NewBroker(bus, 10))
+	noteID, userID := uuid.New(), uuid.New()
+
+	// a heartbeat that skipped the check does not count as one
+	tracker.Heartbeat(noteID, userID, "tab-1", Online, false)
+	if tracker.Verified(noteID, userID, "tab-1") {
+		t.Fatal("session verified without a check")
+	}
+
+	tracker.Heartbeat(noteID, userID, "tab-1", Online, true)
+	if !tracker.Verified(noteID, userID, "tab-1") {
+		t.Fatal("session not verified after a check")
+	}
+
+	// the check is trusted for refreshInterval, then has to be repeated
+	tracker.mu.Lock()
+	tracker.notes[noteID][key(userID, "tab-1")].verified = time.Now().Add(-refreshInterval)
+	tracker.mu.Unlock()
+	if tracker.Verified(noteID, userID, "tab-1") {
+		t.Fatal("stale check still trusted")
+	}
+
+	// sessions announced by another instance were checked there, not here
+	payload, _ := json.Marshal(busMessage{
+		Instance: "other",
+		NoteID:   noteID,
+		Viewer:   Viewer{UserID: userID, SessionID: "tab-2", Status: Online, LastSeen: time.Now()},
+	})
+	tracker.receive(payload)
+	if len(tracker.Viewers(noteID)) != 2 || tracker.Verified(noteID, userID, "tab-2") {
+		t.Fatal("remote session trusted without a local check")
+	}
+}
@@ -0,0 +1,710 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"taskchat/database"
+	"taskchat/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type gormStore struct {
+	db *gorm.DB
+}
+
+// NewGormStore returns a Store backed by db
+func NewGormStore(db *gorm.DB) Store {
+	return &gormStore{db: db}
+}
+
+func (s *gormStore) Users() UserRepository         { return &gormUsers{db: s.db} }
+func (s *gormStore) Notes() NoteRepository         { return &gormNotes{db: s.db} }
+func (s *gormStore) Tasks() TaskRepository         { return &gormTasks{db: s.db} }
+func (s *gormStore) Revisions() RevisionRepository { return &gormRevisions{db: s.db} }
+func (s *gormStore) Activity() ActivityRepository  { return &gormActivity{db: s.db} }
+func (s *gormStore) Reads() ReadRepository         { return &gormReads{db: s.db} }
+func (s *gormStore) Outbox() OutboxRepository      { return &gormOutbox{db: s.db} }
+func (s *gormStore) Webhooks() WebhookRepository   { return &gormWebhooks{db: s.db} }
+func (s *gormStore) Audit() AuditRepository        { return &gormAudit{db: s.db} }
+func (s *gormStore) Context() context.Context      { return s.db.Statement.Context }
+
+func (s *gormStore) WithContext(ctx context.Context) Store {
+	return &gormStore{db: s.db.WithContext(ctx)}
+}
+
+func (s *gormStore) Transaction(fn func(tx Store) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&gormStore{db: tx})
+	})
+}
+
+func (s *gormStore) SavePoint(name string) error {
+	return s.db.SavePoint(name).Error
+}
+
+func (s *gormStore) RollbackTo(name string) error {
+	return s.db.RollbackTo(name).Error
+}
+
+func (s *gormStore) Ping(ctx context.Context) (sql.DBStats, error) {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return sql.DBStats{}, err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return sql.DBStats{}, err
+	}
+	return sqlDB.Stats(), nil
+}
+
+func (s *gormStore) PendingMigrations(ctx context.Context) ([]int64, error) {
+	return database.PendingMigrations(ctx, s.db)
+}
+
+// skipLocked makes concurrent dispatchers on postgres take different rows,
+// sqlite only has one writer anyway
+func skipLocked(query *gorm.DB) *gorm.DB {
+	if query.Dialector.Name() == database.DriverPostgres {
+		return query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	}
+	return query
+}
+
+// first loads a single record, translating gorm's not found error
+func first(query *gorm.DB, dest interface{}) error {
+	err := query.First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+type gormUsers struct {
+	db *gorm.DB
+}
+
+func (r *gormUsers) FindByID(id uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := first(r.db.Where("id=?", id), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) FindByEmail(email string) (*models.User, error) {
+	var user models.User
+	if err := first(r.db.Where("email=?", email), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *gormUsers) Create(user *models.User) error {
+	return r.db.Create(user).Error
+}
+
+type gormNotes struct {
+	db *gorm.DB
+}
+
+func (r *gormNotes) ListByUser(userID uuid.UUID) ([]models.Note, error) {
+	var notes []models.Note
+	err := r.db.Where("user_id=?", userID).Find(&notes).Error
+	return notes, err
+}
+
+func (r *gormNotes) Find(userID, noteID uuid.UUID) (*models.Note, error) {
+	var note models.Note
+	if err := first(r.db.Where("id=? AND user_id=?", noteID, userID), &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (r *gormNotes) FindAny(userID, noteID uuid.UUID) (*models.Note, error) {
+	var note models.Note
+	if err := first(r.db.Unscoped().Where("id=? AND user_id=?", noteID, userID), &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (r *gormNotes) FindTrashed(userID, noteID uuid.UUID) (*models.Note, error) {
+	var note models.Note
+	if err := first(r.db.Unscoped().Where("id=? AND user_id=? AND deleted_at IS NOT NULL", noteID, userID), &note); err != nil {
+		return nil, err
+	}
+	return &note, nil
+}
+
+func (r *gormNotes) ListTrashed(userID uuid.UUID) ([]models.Note, error) {
+	var notes []models.Note
+	err := r.db.Unscoped().Where("user_id=? AND deleted_at IS NOT NULL", userID).Order("deleted_at DESC").Find(&notes).Error
+	return notes, err
+}
+
+func (r *gormNotes) Create(note *models.Note) error {
+	return r.db.Create(note).Error
+}
+
+func (r *gormNotes) Save(note *models.Note) error {
+	return r.db.Save(note).Error
+}
+
+func (r *gormNotes) Trash(note *models.Note, at time.Time) error {
+	// the tasks share the note's timestamp so a restore brings back exactly these
+	if err := r.db.Model(&models.Task{}).Where("note_id=?", note.ID).Update("deleted_at", at).Error; err != nil {
+		return err
+	}
+	if err := r.db.Model(note).Update("deleted_at", at).Error; err != nil {
+		return err
+	}
+	note.DeletedAt = gorm.DeletedAt{Time: at, Valid: true}
+	return nil
+}
+
+func (r *gormNotes) Restore(note *models.Note) error {
+	if err := r.db.Unscoped().Model(&models.Task{}).Where("note_id=? AND deleted_at=?", note.ID, note.DeletedAt.Time).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	if err := r.db.Unscoped().Model(note).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	note.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (r *gormNotes) Purge(note *models.Note) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		_, err := purgeNotes(tx, tx.Unscoped().Model(&models.Note{}).Select("id").Where("id=?", note.ID))
+		return err
+	})
+}
+
+func (r *gormNotes) EmptyTrash(userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		trashedTasks := tx.Unscoped().Model(&models.Task{}).Select("id").Where("user_id=? AND deleted_at IS NOT NULL", userID)
+		if _, err := purgeTasks(tx, trashedTasks); err != nil {
+			return err
+		}
+		_, err := purgeNotes(tx, tx.Unscoped().Model(&models.Note{}).Select("id").Where("user_id=? AND deleted_at IS NOT NULL", userID))
+		return err
+	})
+}
+
+func (r *gormNotes) PurgeExpired(cutoff time.Time) (int64, error) {
+	var purged int64
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		tasks, err := purgeTasks(tx, tx.Unscoped().Model(&models.Task{}).Select("id").Where("deleted_at < ?", cutoff))
+		if err != nil {
+			return err
+		}
+
+		notes, err := purgeNotes(tx, tx.Unscoped().Model(&models.Note{}).Select("id").Where("deleted_at < ?", cutoff))
+		if err != nil {
+			return err
+		}
+
+		purged = tasks + notes
+		return nil
+	})
+
+	return purged, err
+}
+
+// purgeNotes permanently deletes the notes whose ids the noteIDs subquery
+// selects, with their tasks and everything recorded about them. it returns
+// how many notes and tasks were deleted
+func purgeNotes(tx *gorm.DB, noteIDs *gorm.DB) (int64, error) {
+	tasks, err := purgeTasks(tx, tx.Unscoped().Model(&models.Task{}).Select("id").Where("note_id IN (?)", noteIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	// webhooks scoped to the notes go with their deliveries and attempts
+	hooks := tx.Model(&models.Webhook{}).Select("id").Where("note_id IN (?)", noteIDs)
+	deliveries := tx.Model(&models.WebhookDelivery{}).Select("id").Where("webhook_id IN (?)", hooks)
+	dependents := []struct {
+		model interface{}
+		query string
+		ids   *gorm.DB
+	}{
+		{&models.WebhookAttempt{}, "delivery_id IN (?)", deliveries},
+		{&models.WebhookDelivery{}, "webhook_id IN (?)", hooks},
+		{&models.Webhook{}, "note_id IN (?)", noteIDs},
+		{&models.Revision{}, "entity_type='note' AND entity_id IN (?)", noteIDs},
+		{&models.Activity{}, "note_id IN (?)", noteIDs},
+		{&models.NoteRead{}, "note_id IN (?)", noteIDs},
+	}
+	for _, dependent := range dependents {
+		if err := tx.Where(dependent.query, dependent.ids).Delete(dependent.model).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	notes := tx.Unscoped().Where("id IN (?)", noteIDs).Delete(&models.Note{})
+	if notes.Error != nil {
+		return 0, notes.Error
+	}
+	return tasks + notes.RowsAffected, nil
+}
+
+// purgeTasks permanently deletes the tasks whose ids the taskIDs subquery
+// selects, with their revisions and activity, and returns how many it deleted
+func purgeTasks(tx *gorm.DB, taskIDs *gorm.DB) (int64, error) {
+	if err := tx.Where("entity_type='task' AND entity_id IN (?)", taskIDs).Delete(&models.Revision{}).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("task_id IN (?)", taskIDs).Delete(&models.Activity{}).Error; err != nil {
+		return 0, err
+	}
+
+	tasks := tx.Unscoped().Where("id IN (?)", taskIDs).Delete(&models.Task{})
+	return tasks.RowsAffected, tasks.Error
+}
+
+type gormTasks struct {
+	db *gorm.DB
+}
+
+func (r *gormTasks) ListByNote(userID, noteID uuid.UUID) ([]models.Task, error) {
+	var tasks []models.Task
+	err := r.db.Where("note_id=? AND user_id=?", noteID, userID).Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *gormTasks) ListByPriority(userID uuid.UUID, priority string) ([]models.Task, error) {
+	var tasks []models.Task
+	err := r.db.Where("user_id=? AND priority =?", userID, priority).Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *gormTasks) Find(userID, taskID uuid.UUID) (*models.Task, error) {
+	var task models.Task
+	if err := first(r.db.Where("id=? AND user_id=?", taskID, userID), &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *gormTasks) FindAny(userID, taskID uuid.UUID) (*models.Task, error) {
+	var task models.Task
+	if err := first(r.db.Unscoped().Where("id=? AND user_id=?", taskID, userID), &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *gormTasks) FindTrashed(userID, taskID uuid.UUID) (*models.Task, error) {
+	var task models.Task
+	if err := first(r.db.Unscoped().Where("id=? AND user_id=? AND deleted_at IS NOT NULL", taskID, userID), &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (r *gormTasks) ListTrashed(userID uuid.UUID) ([]models.Task, error) {
+	var tasks []models.Task
+	liveNotes := r.db.Model(&models.Note{}).Select("id").Where("user_id=?", userID)
+	err := r.db.Unscoped().Where("user_id=? AND deleted_at IS NOT NULL AND note_id IN (?)", userID, liveNotes).Order("deleted_at DESC").Find(&tasks).Error
+	return tasks, err
+}
+
+func (r *gormTasks) Create(task *models.Task) error {
+	return r.db.Create(task).Error
+}
+
+func (r *gormTasks) Save(task *models.Task) error {
+	return r.db.Save(task).Error
+}
+
+func (r *gormTasks) Trash(task *models.Task) error {
+	return r.db.Delete(task).Error
+}
+
+func (r *gormTasks) Restore(task *models.Task) error {
+	if err := r.db.Unscoped().Model(task).Update("deleted_at", nil).Error; err != nil {
+		return err
+	}
+	task.DeletedAt = gorm.DeletedAt{}
+	return nil
+}
+
+func (r *gormTasks) Purge(userID, taskID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		purged, err := purgeTasks(tx, tx.Unscoped().Model(&models.Task{}).Select("id").Where("id=? AND user_id=? AND deleted_at IS NOT NULL", taskID, userID))
+		if err != nil {
+			return err
+		}
+		if purged == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+type gormRevisions struct {
+	db *gorm.DB
+}
+
+func (r *gormRevisions) Create(revision *models.Revision) error {
+	return r.db.Create(revision).Error
+}
+
+func (r *gormRevisions) ListByEntity(entityType string, entityID uuid.UUID) ([]models.Revision, error) {
+	var revisions []models.Revision
+	err := r.db.Where("entity_type=? AND entity_id=?", entityType, entityID).Order("created_at DESC").Find(&revisions).Error
+	return revisions, err
+}
+
+func (r *gormRevisions) Find(entityType string, entityID, revisionID uuid.UUID) (*models.Revision, error) {
+	var revision models.Revision
+	if err := first(r.db.Where("id=? AND entity_type=? AND entity_id=?", revisionID, entityType, entityID), &revision); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+type gormActivity struct {
+	db *gorm.DB
+}
+
+func (r *gormActivity) Create(activity *models.Activity) error {
+	return r.db.Create(activity).Error
+}
+
+func (r *gormActivity) ListByNote(noteID uuid.UUID, after ActivityCursor, limit int) ([]models.Activity, error) {
+	return r.page(r.db.Where("note_id=?", noteID), after, limit)
+}
+
+func (r *gormActivity) ListByUser(userID uuid.UUID, since time.Time, after ActivityCursor, limit int) ([]models.Activity, error) {
+	return r.page(r.userFeed(userID, since), after, limit)
+}
+
+func (r *gormActivity) CountByUser(userID uuid.UUID, since time.Time) (map[string]int64, error) {
+	var rows []struct {
+		Type  string
+		Count int64
+	}
+	if err := r.userFeed(userID, since).Select("type, COUNT(*) AS count").Group("type").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Type] = row.Count
+	}
+	return counts, nil
+}
+
+// userFeed is the activity after since on the user's notes, trashed or not
+func (r *gormActivity) userFeed(userID uuid.UUID, since time.Time) *gorm.DB {
+	userNotes := r.db.Unscoped().Model(&models.Note{}).Select("id").Where("user_id=?", userID)
+	return r.db.Model(&models.Activity{}).Where("note_id IN (?) AND created_at > ?", userNotes, since)
+}
+
+func (r *gormActivity) page(query *gorm.DB, after ActivityCursor, limit int) ([]models.Activity, error) {
+	// the id breaks ties so entries sharing a timestamp are neither skipped
+	// nor repeated across pages
+	if !after.CreatedAt.IsZero() {
+		query = query.Where("created_at < ? OR (created_at = ? AND id < ?)", after.CreatedAt, after.CreatedAt, after.ID)
+	}
+
+	var activities []models.Activity
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&activities).Error
+	return activities, err
+}
+
+type gormReads struct {
+	db *gorm.DB
+}
+
+func (r *gormReads) Save(read *models.NoteRead) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "note_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_read_at"}),
+	}).Create(read).Error
+}
+
+func (r *gormReads) ListByNotes(userID uuid.UUID, noteIDs []uuid.UUID) ([]models.NoteRead, error) {
+	var reads []models.NoteRead
+	err := r.db.Where("user_id=? AND note_id IN ?", userID, noteIDs).Find(&reads).Error
+	return reads, err
+}
+
+func (r *gormReads) CountUnreadTasks(userID uuid.UUID, noteIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	var rows []struct {
+		NoteID uuid.UUID
+		Count  int64
+	}
+	err := r.db.Table("activities AS a").
+		Select("a.note_id, COUNT(DISTINCT a.task_id) AS count").
+		Joins("LEFT JOIN note_reads AS r ON r.note_id = a.note_id AND r.user_id = ?", userID).
+		Where("a.note_id IN ? AND a.task_id IS NOT NULL", noteIDs).
+		Where("r.last_read_at IS NULL OR a.created_at > r.last_read_at").
+		Group("a.note_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		counts[row.NoteID] = row.Count
+	}
+	return counts, nil
+}
+
+type gormOutbox struct {
+	db *gorm.DB
+}
+
+func (r *gormOutbox) Record(eventType string, userID, noteID uuid.UUID, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	var payload models.JSON
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return err
+	}
+
+	return r.db.Create(&models.OutboxEvent{
+		ID:      uuid.New(),
+		Type:    eventType,
+		UserID:  userID,
+		NoteID:  noteID,
+		Payload: payload,
+	}).Error
+}
+
+func (r *gormOutbox) ListPending(limit int) ([]models.OutboxEvent, error) {
+	var pending []models.OutboxEvent
+	err := skipLocked(r.db.Where("published_at IS NULL").Order("created_at").Limit(limit)).Find(&pending).Error
+	return pending, err
+}
+
+func (r *gormOutbox) MarkPublished(ids []uuid.UUID, at time.Time) error {
+	return r.db.Model(&models.OutboxEvent{}).Where("id IN ?", ids).Update("published_at", at).Error
+}
+
+func (r *gormOutbox) DeletePublished(cutoff time.Time) (int64, error) {
+	result := r.db.Where("published_at < ?", cutoff).Delete(&models.OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
+
+type gormWebhooks struct {
+	db *gorm.DB
+}
+
+func (r *gormWebhooks) ListByUser(userID uuid.UUID) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.db.Where("user_id=?", userID).Order("created_at").Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *gormWebhooks) Find(userID, webhookID uuid.UUID) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := first(r.db.Where("id=? AND user_id=?", webhookID, userID), &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (r *gormWebhooks) Create(webhook *models.Webhook) error {
+	return r.db.Create(webhook).Error
+}
+
+func (r *gormWebhooks) Save(webhook *models.Webhook) error {
+	return r.db.Save(webhook).Error
+}
+
+func (r *gormWebhooks) Delete(webhook *models.Webhook) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		deliveries := tx.Model(&models.WebhookDelivery{}).Select("id").Where("webhook_id=?", webhook.ID)
+		if err := tx.Where("delivery_id IN (?)", deliveries).Delete(&models.WebhookAttempt{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("webhook_id=?", webhook.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(webhook).Error
+	})
+}
+
+func (r *gormWebhooks) ListDeliveries(webhookID uuid.UUID, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.Preload("DeliveryAttempts", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Where("webhook_id=?", webhookID).Order("created_at DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r *gormWebhooks) Get(webhookID uuid.UUID) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := first(r.db.Where("id=?", webhookID), &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+func (r *gormWebhooks) ListActive(userID, noteID uuid.UUID) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+	err := r.db.Where("user_id=? AND active=? AND (note_id IS NULL OR note_id=?)", userID, true, noteID).Find(&hooks).Error
+	return hooks, err
+}
+
+func (r *gormWebhooks) QueueDeliveries(deliveries []models.WebhookDelivery) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error
+}
+
+func (r *gormWebhooks) CreateDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Create(delivery).Error
+}
+
+func (r *gormWebhooks) FindDelivery(deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	query := r.db.Preload("DeliveryAttempts", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Where("id=?", deliveryID)
+	if err := first(query, &delivery); err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+func (r *gormWebhooks) ListDue(now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var due []models.WebhookDelivery
+	query := r.db.Where("status=? AND next_attempt_at <= ?", models.DeliveryPending, now).Order("next_attempt_at").Limit(limit)
+	err := skipLocked(query).Find(&due).Error
+	return due, err
+}
+
+func (r *gormWebhooks) Reschedule(ids []uuid.UUID, at time.Time) error {
+	return r.db.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", at).Error
+}
+
+func (r *gormWebhooks) SaveDelivery(delivery *models.WebhookDelivery) error {
+	return r.db.Model(delivery).Select("status", "attempts", "next_attempt_at").Updates(delivery).Error
+}
+
+func (r *gormWebhooks) CreateAttempt(attempt *models.WebhookAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+func (r *gormWebhooks) ResetFailures(webhook *models.Webhook) error {
+	if err := r.db.Model(webhook).Update("failure_count", 0).Error; err != nil {
+		return err
+	}
+	webhook.FailureCount = 0
+	return nil
+}
+
+func (r *gormWebhooks) CountFailure(webhook *models.Webhook) error {
+	if err := r.db.Model(webhook).Update("failure_count", gorm.Expr("failure_count + 1")).Error; err != nil {
+		return err
+	}
+	return r.db.Where("id=?", webhook.ID).First(webhook).Error
+}
+
+func (r *gormWebhooks) Disable(webhook *models.Webhook, at time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(webhook).Updates(map[string]interface{}{"active": false, "disabled_at": at}).Error; err != nil {
+			return err
+		}
+		webhook.Active, webhook.DisabledAt = false, &at
+
+		return tx.Model(&models.WebhookDelivery{}).Where("webhook_id=? AND status=?", webhook.ID, models.DeliveryPending).Update("status", models.DeliveryFailed).Error
+	})
+}
+
+// auditLockKey is the postgres advisory lock that serialises appends across instances
+const auditLockKey = 0x7461736b61756474
+
+// auditMu serialises appends within this instance
+var auditMu sync.Mutex
+
+type gormAudit struct {
+	db *gorm.DB
+}
+
+func (r *gormAudit) Append(next func(last *models.AuditLog) (*models.AuditLog, error)) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == database.DriverPostgres {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditLockKey).Error; err != nil {
+				return err
+			}
+		}
+
+		var last models.AuditLog
+		lastRow := &last
+		if err := first(tx.Order("seq DESC"), &last); errors.Is(err, ErrNotFound) {
+			lastRow = nil
+		} else if err != nil {
+			return err
+		}
+
+		row, err := next(lastRow)
+		if err != nil {
+			return err
+		}
+		return tx.Create(row).Error
+	})
+}
+
+func (r *gormAudit) List(filter AuditFilter, limit int) ([]models.AuditLog, error) {
+	query := r.filter(filter)
+	if filter.BeforeSeq > 0 {
+		query = query.Where("seq < ?", filter.BeforeSeq)
+	}
+
+	var entries []models.AuditLog
+	err := query.Order("seq DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+func (r *gormAudit) Export(filter AuditFilter, fn func(batch []models.AuditLog) error) error {
+	var batch []models.AuditLog
+	// batches are walked in primary key order, which is seq
+	return r.filter(filter).FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+func (r *gormAudit) filter(filter AuditFilter) *gorm.DB {
+	query := r.db.Model(&models.AuditLog{})
+	if filter.Event != "" {
+		query = query.Where("event=?", filter.Event)
+	}
+	if filter.UserID != nil {
+		query = query.Where("user_id=?", *filter.UserID)
+	}
+	if filter.Email != "" {
+		query = query.Where("email=?", filter.Email)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip=?", filter.IP)
+	}
+	if filter.Success != nil {
+		query = query.Where("success=?", *filter.Success)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	return query
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"taskchat/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned when the record does not exist or belongs to
+// another user
+var ErrNotFound = errors.New("record not found")
+
+type UserRepository interface {
+	FindByID(id uuid.UUID) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	Create(user *models.User) error
+}
+
+// NoteRepository reads and writes the notes of a user. Find only sees notes
+// outside the trash, FindTrashed only notes inside it
+type NoteRepository interface {
+	ListByUser(userID uuid.UUID) ([]models.Note, error)
+	Find(userID, noteID uuid.UUID) (*models.Note, error)
+	// FindAny also finds the note while it is in the trash
+	FindAny(userID, noteID uuid.UUID) (*models.Note, error)
+	FindTrashed(userID, noteID uuid.UUID) (*models.Note, error)
+	ListTrashed(userID uuid.UUID) ([]models.Note, error)
+	Create(note *models.Note) error
+	Save(note *models.Note) error
+	// Trash moves the note and its tasks to the trash stamped with at
+	Trash(note *models.Note, at time.Time) error
+	// Restore brings the note back with the tasks trashed along with it
+	Restore(note *models.Note) error
+	// Purge permanently deletes the note and all of its tasks, together with
+	// their history, activity, reads and the webhooks scoped to the note
+	Purge(note *models.Note) error
+	// EmptyTrash permanently deletes every trashed note and task of the user
+	EmptyTrash(userID uuid.UUID) error
+	// PurgeExpired permanently deletes the notes and tasks of every user
+	// trashed before cutoff and returns how many rows went
+	PurgeExpired(cutoff time.Time) (int64, error)
+}
+
+// TaskRepository reads and writes the tasks of a user, with the same trash
+// rules as NoteRepository
+type TaskRepository interface {
+	ListByNote(userID, noteID uuid.UUID) ([]models.Task, error)
+	ListByPriority(userID uuid.UUID, priority string) ([]models.Task, error)
+	Find(userID, taskID uuid.UUID) (*models.Task, error)
+	FindAny(userID, taskID uuid.UUID) (*models.Task, error)
+	FindTrashed(userID, taskID uuid.UUID) (*models.Task, error)
+	// ListTrashed returns the tasks trashed on their own from notes that still exist
+	ListTrashed(userID uuid.UUID) ([]models.Task, error)
+	Create(task *models.Task) error
+	Save(task *models.Task) error
+	Trash(task *models.Task) error
+	Restore(task *models.Task) error
+	// Purge permanently deletes a trashed task with its history and activity,
+	// ErrNotFound when there is none
+	Purge(userID, taskID uuid.UUID) error
+}
+
+// RevisionRepository keeps the history of notes and tasks
+type RevisionRepository interface {
+	Create(revision *models.Revision) error
+	// ListByEntity returns the revisions of a note or task, newest first
+	ListByEntity(entityType string, entityID uuid.UUID) ([]models.Revision, error)
+	Find(entityType string, entityID, revisionID uuid.UUID) (*models.Revision, error)
+}
+
+// ActivityCursor is the last entry of a feed page, the next page starts right
+// after it. The zero cursor starts at the newest entry
+type ActivityCursor struct {
+	CreatedAt time.Time
+	ID        uuid.UUID
+}
+
+// ActivityRepository stores and pages through the activity feeds, newest
+// first, entries created at the same time ordered by id
+type ActivityRepository interface {
+	Create(activity *models.Activity) error
+	ListByNote(noteID uuid.UUID, after ActivityCursor, limit int) ([]models.Activity, error)
+	// ListByUser pages through what happened after since on every note of
+	// the user, the trashed ones included
+	ListByUser(userID uuid.UUID, since time.Time, after ActivityCursor, limit int) ([]models.Activity, error)
+	// CountByUser counts what happened after since on the user's notes by type
+	CountByUser(userID uuid.UUID, since time.Time) (map[string]int64, error)
+}
+
+// ReadRepository keeps how far each user has read their notes
+type ReadRepository interface {
+	// Save creates or moves the read pointer of the user on the note
+	Save(read *models.NoteRead) error
+	ListByNotes(userID uuid.UUID, noteIDs []uuid.UUID) ([]models.NoteRead, error)
+	// CountUnreadTasks counts by note the tasks with activity after the read
+	// pointer, every task with activity when the note was never read
+	CountUnreadTasks(userID uuid.UUID, noteIDs []uuid.UUID) (map[uuid.UUID]int64, error)
+}
+
+// OutboxRepository queues change events, they are published once the
+// transaction that recorded them commits
+type OutboxRepository interface {
+	Record(eventType string, userID, noteID uuid.UUID, data interface{}) error
+	// ListPending returns up to limit unpublished events in the order they
+	// were written, locked against other dispatchers until the transaction ends
+	ListPending(limit int) ([]models.OutboxEvent, error)
+	MarkPublished(ids []uuid.UUID, at time.Time) error
+	// DeletePublished removes the events published before cutoff
+	DeletePublished(cutoff time.Time) (int64, error)
+}
+
+// WebhookRepository reads and writes the webhooks of a user and their deliveries
+type WebhookRepository interface {
+	ListByUser(userID uuid.UUID) ([]models.Webhook, error)
+	Find(userID, webhookID uuid.UUID) (*models.Webhook, error)
+	Create(webhook *models.Webhook) error
+	Save(webhook *models.Webhook) error
+	// Delete removes the webhook together with its delivery log
+	Delete(webhook *models.Webhook) error
+	// ListDeliveries returns the latest deliveries, each with its attempts in order
+	ListDeliveries(webhookID uuid.UUID, limit int) ([]models.WebhookDelivery, error)
+
+	// Get finds a webhook whoever it belongs to, for the dispatcher
+	Get(webhookID uuid.UUID) (*models.Webhook, error)
+	// ListActive returns the active webhooks of the user covering the note
+	ListActive(userID, noteID uuid.UUID) ([]models.Webhook, error)
+	// QueueDeliveries writes the deliveries, skipping those already queued
+	// for the same event and webhook
+	QueueDeliveries(deliveries []models.WebhookDelivery) error
+	CreateDelivery(delivery *models.WebhookDelivery) error
+	// FindDelivery returns the delivery with its attempts in order
+	FindDelivery(deliveryID uuid.UUID) (*models.WebhookDelivery, error)
+	// ListDue returns up to limit pending deliveries whose next attempt is
+	// due, locked against other dispatchers until the transaction ends
+	ListDue(now time.Time, limit int) ([]models.WebhookDelivery, error)
+	// Reschedule moves the next attempt of the deliveries to at
+	Reschedule(ids []uuid.UUID, at time.Time) error
+	// SaveDelivery writes the status, attempts and next attempt of the delivery
+	SaveDelivery(delivery *models.WebhookDelivery) error
+	CreateAttempt(attempt *models.WebhookAttempt) error
+	ResetFailures(webhook *models.Webhook) error
+	// CountFailure adds one to the failure counter in the database, where
+	// other instances count too, and reloads the webhook
+	CountFailure(webhook *models.Webhook) error
+	// Disable switches the webhook off at at and fails its pending deliveries
+	Disable(webhook *models.Webhook, at time.Time) error
+}
+
+// AuditFilter selects audit log entries, zero fields match every entry
+type AuditFilter struct {
+	Event   string
+	UserID  *uuid.UUID
+	Email   string
+	IP      string
+	Success *bool
+	// From is inclusive and To exclusive
+	From time.Time
+	To   time.Time
+	// BeforeSeq pages backwards through the chain
+	BeforeSeq int64
+}
+
+// AuditRepository appends to and reads the audit log hash chain
+type AuditRepository interface {
+	// Append writes the row next builds from the last row of the chain, nil
+	// while the chain is empty. Appends from every instance are serialised
+	// so the chain never forks
+	Append(next func(last *models.AuditLog) (*models.AuditLog, error)) error
+	// List returns the matching entries, newest first
+	List(filter AuditFilter, limit int) ([]models.AuditLog, error)
+	// Export passes the matching entries to fn a batch at a time in chain order
+	Export(filter AuditFilter, fn func(batch []models.AuditLog) error) error
+}
+
+// Store hands out repositories bound to one connection or transaction
+type Store interface {
+	Users() UserRepository
+	Notes() NoteRepository
+	Tasks() TaskRepository
+	Revisions() RevisionRepository
+	Activity() ActivityRepository
+	Reads() ReadRepository
+	Outbox() OutboxRepository
+	Webhooks() WebhookRepository
+	Audit() AuditRepository
+	// Transaction runs fn in a transaction, everything written through the
+	// store passed to fn commits or rolls back together
+	Transaction(fn func(tx Store) error) error
+	// SavePoint and RollbackTo undo part of a transaction, they are only
+	// valid on the store passed to Transaction
+	SavePoint(name string) error
+	RollbackTo(name string) error
+	// Ping checks the database answers and reports how busy the pool is
+	Ping(ctx context.Context) (sql.DBStats, error)
+	// PendingMigrations lists the migrations the schema is missing
+	PendingMigrations(ctx context.Context) ([]int64, error)
+	// Context is what the store's queries run under, for logging next to them
+	Context() context.Context
+	// WithContext returns a store whose queries run under ctx, so they are
+	// cancelled and traced along with the request that made them
+	WithContext(ctx context.Context) Store
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"taskchat/config"
+	"taskchat/events"
+	"taskchat/handlers"
+	"taskchat/metrics"
+	"taskchat/middleware"
+	"taskchat/presence"
+	"taskchat/repository"
+	"taskchat/webhooks"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// registerRoutes mounts every api route on app, the integration tests build
+// their app with it too so they exercise exactly what main serves
+func registerRoutes(app *fiber.App, cfg config.Config, store repository.Store, hub *events.Broker, tracker *presence.Tracker) {
+	h := handlers.New(store, hub, tracker, webhooks.NewService(store, cfg.Webhooks.AllowPrivateTargets))
+
+	app.Get("/api/health", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"status": "ok"})
+	})
+
+	// scraped by prometheus, outside /api so it is not rate limited
+	app.Get("/metrics", metrics.Handler())
+
+	// probes for the orchestrator, readiness also checks what the app depends
+	// on. outside /api like /metrics so a busy limiter cannot fail them
+	app.Get("/health/live", h.Liveness)
+	app.Get("/health/ready", h.Readiness)
+
+	app.Post("/api/register", h.Register)
+	app.Post("/api/login", h.Login)
+
+	app.Get("/api/protected", middleware.AuthMiddleware, func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "Protected route accessed"})
+	})
+
+	// the tasks group below runs AuthMiddleware on every /api path, so the
+	// stream has to be matched first for its query token to be picked up
+	app.Get("/api/events", middleware.TokenFromQuery, middleware.AuthMiddleware, h.StreamEvents)
+
+	notes := app.Group("/api/notes", middleware.AuthMiddleware)
+	notes.Get("/", h.GetNotes)
+	notes.Post("/", h.CreateNote)
+	notes.Put("/:id", h.UpdateNote)
+	notes.Delete("/:id", h.DeleteNote)
+	notes.Get("/:id/history", h.GetNoteHistory)
+	notes.Post("/:id/history/:revision_id/revert", h.RevertNote)
+	notes.Get("/:id/activity", h.GetNoteActivity)
+	notes.Post("/:id/read", h.MarkNoteRead)
+	notes.Get("/:id/presence", h.GetPresence)
+	notes.Post("/:id/presence", h.Heartbeat)
+	notes.Delete("/:id/presence", h.LeaveNote)
+
+	tasks := app.Group("/api", middleware.AuthMiddleware)
+	tasks.Get("/notes/:note_id/tasks", h.GetTasks)
+	tasks.Post("/notes/:note_id/tasks", h.CreateTask)
+	tasks.Put("/tasks/:id", h.UpdateTask)
+	tasks.Delete("/tasks/:id", h.DeleteTask)
+	tasks.Get("/tasks/:id/history", h.GetTaskHistory)
+	tasks.Post("/tasks/:id/history/:revision_id/revert", h.RevertTask)
+
+	app.Get("/api/priorities", middleware.AuthMiddleware, h.GetPriorities)
+	app.Post("/api/batch", middleware.AuthMiddleware, h.Batch)
+	app.Get("/api/activity", middleware.AuthMiddleware, h.GetActivity)
+
+	webhookRoutes := app.Group("/api/webhooks", middleware.AuthMiddleware)
+	webhookRoutes.Get("/", h.GetWebhooks)
+	webhookRoutes.Post("/", h.CreateWebhook)
+	webhookRoutes.Put("/:id", h.UpdateWebhook)
+	webhookRoutes.Delete("/:id", h.DeleteWebhook)
+	webhookRoutes.Post("/:id/test", h.TestWebhook)
+	webhookRoutes.Get("/:id/deliveries", h.GetWebhookDeliveries)
+
+	admin := app.Group("/api/admin", middleware.AuthMiddleware, middleware.AdminMiddleware(store.Users(), cfg.AdminEmails))
+	admin.Get("/audit", h.GetAuditLogs)
+	admin.Get("/audit/export", h.ExportAuditLogs)
+	admin.Get("/audit/verify", h.VerifyAuditLog)
+
+	trash := app.Group("/api/trash", middleware.AuthMiddleware)
+	trash.Get("/", h.GetTrash)
+	trash.Delete("/", h.EmptyTrash)
+	trash.Post("/notes/:id/restore", h.RestoreNote)
+	trash.Post("/tasks/:id/restore", h.RestoreTask)
+	trash.Delete("/notes/:id", h.PurgeNote)
+	trash.Delete("/tasks/:id", h.PurgeTask)
+}
@@ -0,0 +1,1108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"taskchat/config"
+	"taskchat/database"
+	"taskchat/handlers"
+	"taskchat/logging"
+	"taskchat/metrics"
+	"taskchat/models"
+	"taskchat/outbox"
+	"taskchat/repository"
+	"taskchat/tracing"
+	"taskchat/webhooks"
+	"taskchat/workers"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestHealth(t *testing.T) {
+	a := newTestApp(t)
+
+	resp := a.expect(a.request(http.MethodGet, "/api/health", "", nil), http.StatusOK)
+	if !strings.Contains(string(resp.Body), `"ok"`) {
+		t.Fatalf("unexpected health body %s", resp.Body)
+	}
+
+	a.expect(a.request(http.MethodGet, "/health/live", "", nil), http.StatusOK)
+
+	// readiness reports each dependency on its own
+	readiness := func(status int) map[string]handlers.HealthCheck {
+		t.Helper()
+		var report struct {
+			Status string
+			Checks map[string]handlers.HealthCheck
+		}
+		if err := json.Unmarshal(a.expect(a.request(http.MethodGet, "/health/ready", "", nil), status).Body, &report); err != nil {
+			t.Fatalf("decode readiness: %v", err)
+		}
+		return report.Checks
+	}
+
+	checks := readiness(http.StatusOK)
+	for _, name := range []string{"database", "migrations", "workers"} {
+		if checks[name].Status != "ok" {
+			t.Fatalf("check %s is %+v", name, checks[name])
+		}
+	}
+
+	// an unhealthy worker is reported without taking the instance out of rotation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	workers.RunWebhookDispatcher(ctx, webhooks.NewService(repository.NewGormStore(a.db), false), time.Second)
+	if checks := readiness(http.StatusOK); checks["workers"].Status != "failing" || !checks["workers"].Informational {
+		t.Fatalf("unexpected workers check %+v", checks["workers"])
+	}
+
+	// a schema behind the binary is not ready
+	if _, err := database.MigrateDown(a.db, 1); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if checks := readiness(http.StatusServiceUnavailable); checks["migrations"].Status != "failing" || checks["database"].Status != "ok" {
+		t.Fatalf("unexpected checks after rollback %+v", checks)
+	}
+
+	// neither is a closed pool, while liveness stays up
+	sqlDB, _ := a.db.DB()
+	sqlDB.Close()
+	if checks := readiness(http.StatusServiceUnavailable); checks["database"].Status != "failing" {
+		t.Fatalf("unexpected checks after close %+v", checks)
+	}
+
+	// the probes are public, why a check fails only goes to the log
+	if body := a.request(http.MethodGet, "/health/ready", "", nil).Body; strings.Contains(string(body), "closed") {
+		t.Fatalf("readiness exposed the database error: %s", body)
+	}
+	a.expect(a.request(http.MethodGet, "/health/live", "", nil), http.StatusOK)
+}
+
+// the tables as AutoMigrate created them before there were migrations
+type baselineUser struct {
+	ID           uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Email        string    `gorm:"type:varchar(255);unique;not null"`
+	PasswordHash string    `gorm:"type:varchar(255);not null"`
+	CreatedAt    time.Time `gorm:"autoCreateTime"`
+}
+
+type baselineNote struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Title     string    `gorm:"type:varchar(100);not null"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+type baselineTask struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	NoteID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Title     string    `gorm:"type:varchar(255);not null"`
+	Status    string    `gorm:"type:varchar(100);not null;default:'pending'"`
+	Priority  string    `gorm:"type:varchar(100);not null;default:' '"`
+	CreatedAt time.Time `gorm:"autoCreateTime"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime"`
+}
+
+func (baselineUser) TableName() string { return "users" }
+func (baselineNote) TableName() string { return "notes" }
+func (baselineTask) TableName() string { return "tasks" }
+
+func TestMigrateBaselineDatabase(t *testing.T) {
+	db, err := database.Open(config.DatabaseConfig{Driver: database.DriverSQLite, URL: ":memory:"})
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	db.Logger = logger.Default.LogMode(logger.Silent)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(&baselineUser{}, &baselineNote{}, &baselineTask{}); err != nil {
+		t.Fatalf("baseline schema: %v", err)
+	}
+	note := baselineNote{ID: uuid.New(), UserID: uuid.New(), Title: "Before migrations"}
+	if err := db.Create(&note).Error; err != nil {
+		t.Fatalf("baseline note: %v", err)
+	}
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate baseline db: %v", err)
+	}
+
+	for _, model := range []interface{}{&models.Note{}, &models.Task{}} {
+		if !db.Migrator().HasColumn(model, "DeletedAt") || !db.Migrator().HasIndex(model, "DeletedAt") {
+			t.Fatalf("%T has no deleted_at column and index after migrating", model)
+		}
+	}
+	// existing rows survive and can be trashed
+	if err := db.Delete(&models.Note{}, "id = ?", note.ID).Error; err != nil {
+		t.Fatalf("trash note: %v", err)
+	}
+	var trashed models.Note
+	if err := db.Unscoped().First(&trashed, "id = ?", note.ID).Error; err != nil || !trashed.DeletedAt.Valid {
+		t.Fatalf("trashed note %+v: %v", trashed, err)
+	}
+
+	// a database migrated with every column already in place is left as it is
+	states, err := database.MigrationStatus(db)
+	if err != nil {
+		t.Fatalf("migration status: %v", err)
+	}
+	if _, err := database.MigrateDown(db, len(states)-1); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if err := db.Exec("ALTER TABLE notes ADD COLUMN deleted_at datetime").Error; err != nil {
+		t.Fatalf("add column: %v", err)
+	}
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate with existing column: %v", err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	a := newTestApp(t)
+
+	logins := testutil.ToFloat64(metrics.Logins.WithLabelValues("success"))
+	failures := testutil.ToFloat64(metrics.Logins.WithLabelValues("failure"))
+	notes := testutil.ToFloat64(metrics.NotesCreated)
+	tasks := testutil.ToFloat64(metrics.TasksCreated)
+
+	user := a.register("metrics@example.com")
+	a.expect(a.request(http.MethodPost, "/api/login", "", fiber.Map{"email": user.Email, "password": testPassword}), http.StatusCreated)
+	a.expect(a.request(http.MethodPost, "/api/login", "", fiber.Map{"email": user.Email, "password": "wrong-password"}), http.StatusUnauthorized)
+
+	note := a.createNote(user, "Metrics")
+	a.createTask(user, note.ID, "Counted")
+
+	// of a rolled back batch nothing counts, of a partial one only what was committed
+	a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"operations": []fiber.Map{
+		{"op": "create", "type": "note", "data": fiber.Map{"title": "Rolled back"}},
+		{"op": "create", "type": "task", "note_id": "not-a-uuid", "data": fiber.Map{"title": "Fails"}},
+	}})
+	a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"mode": "partial", "operations": []fiber.Map{
+		{"op": "create", "type": "note", "data": fiber.Map{"title": "Committed"}},
+		{"op": "create", "type": "task", "note_id": "not-a-uuid", "data": fiber.Map{"title": "Fails"}},
+	}}), http.StatusOK)
+
+	for name, got := range map[string]float64{
+		"logins":   testutil.ToFloat64(metrics.Logins.WithLabelValues("success")) - logins,
+		"failures": testutil.ToFloat64(metrics.Logins.WithLabelValues("failure")) - failures,
+		"notes":    testutil.ToFloat64(metrics.NotesCreated) - notes,
+		"tasks":    testutil.ToFloat64(metrics.TasksCreated) - tasks,
+	} {
+		want := 1.0
+		if name == "notes" {
+			want = 2
+		}
+		if got != want {
+			t.Fatalf("%s went up by %v, want %v", name, got, want)
+		}
+	}
+
+	// requests are labelled with the route they matched rather than their path
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(metrics.Middleware)
+	app.Get("/things/:id", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) })
+	app.Get("/metrics", metrics.Handler())
+	for _, path := range []string{"/things/1", "/things/2", "/missing"} {
+		if _, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil), -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/metrics", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	for _, series := range []string{
+		`taskchat_http_requests_total{method="GET",route="/things/:id",status="204"} 2`,
+		`taskchat_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`taskchat_http_request_duration_seconds_count{method="GET",route="/things/:id",status="204"} 2`,
+		`taskchat_event_streams_active`,
+		`taskchat_logins_total{result="failure"}`,
+	} {
+		if !strings.Contains(string(body), series) {
+			t.Fatalf("metrics are missing %s:\n%s", series, body)
+		}
+	}
+}
+
+func TestTracing(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("tracing@example.com")
+	note := a.createNote(user, "Traced")
+
+	spans := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(spans))
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	// the same routes behind the tracing middleware and with traced queries
+	if err := tracing.RegisterGORM(a.db); err != nil {
+		t.Fatal(err)
+	}
+	a.app = fiber.New(fiber.Config{DisableStartupMessage: true})
+	a.app.Use(tracing.Middleware)
+	registerRoutes(a.app, config.Config{}, repository.NewGormStore(a.db), a.hub, a.tracker)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/api/notes/"+note.ID+"/tasks", nil)
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	if _, err := a.app.Test(req, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	var server sdktrace.ReadOnlySpan
+	queries := 0
+	for _, span := range spans.GetSpans().Snapshots() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Fatalf("span %q is not in the caller's trace", span.Name())
+		}
+		switch span.SpanKind() {
+		case trace.SpanKindServer:
+			server = span
+		case trace.SpanKindClient:
+			queries++
+		}
+	}
+	if server == nil || server.Name() != "GET /api/notes/:note_id/tasks" {
+		t.Fatalf("expected a server span named after the route, got %+v", spans.GetSpans())
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span parent is %s", server.Parent().SpanID())
+	}
+	// the note lookup and the task listing
+	if queries < 2 {
+		t.Fatalf("expected the queries as spans, got %d", queries)
+	}
+
+	// logs written with the request context carry its trace id
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	defer zerolog.SetGlobalLevel(level)
+
+	var buf bytes.Buffer
+	ctx := trace.ContextWithSpanContext(context.Background(), server.SpanContext())
+	logger := zerolog.New(&buf).Hook(tracing.LogHook{})
+	logger.Error().Ctx(ctx).Msg("failed")
+	if !strings.Contains(buf.String(), `"trace_id":"`+traceID+`"`) {
+		t.Fatalf("log line has no trace id: %s", buf.String())
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("logging@example.com")
+
+	var logs bytes.Buffer
+	logger, level := zlog.Logger, zerolog.GlobalLevel()
+	zlog.Logger = zerolog.New(&logs).Hook(logging.ContextHook{})
+	zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	t.Cleanup(func() {
+		zlog.Logger = logger
+		zerolog.SetGlobalLevel(level)
+	})
+
+	a.app = fiber.New(fiber.Config{DisableStartupMessage: true, ErrorHandler: handlers.ErrorHandler})
+	a.app.Use(logging.Middleware(config.LogConfig{RequestLevel: "info"}))
+	registerRoutes(a.app, config.Config{}, repository.NewGormStore(a.db), a.hub, a.tracker)
+
+	send := func(req *http.Request) *http.Response {
+		t.Helper()
+		resp, err := a.app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// an id from the client is kept, a missing or unusable one is replaced
+	req := httptest.NewRequest(http.MethodGet, "/api/notes?access_token="+user.Token, nil)
+	req.Header.Set("Authorization", "Bearer "+user.Token)
+	req.Header.Set("X-Request-ID", "client-id-1")
+	if id := send(req).Header.Get("X-Request-ID"); id != "client-id-1" {
+		t.Fatalf("client request id not echoed, got %q", id)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/health", nil)
+	req.Header.Set("X-Request-ID", "not valid\n")
+	if id := send(req).Header.Get("X-Request-ID"); id == "" || strings.Contains(id, " ") {
+		t.Fatalf("expected a generated request id, got %q", id)
+	}
+
+	// error bodies carry the id so a report can be matched to the logs
+	req = httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(`{"email":"logging@example.com","password":"wrong-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", "failed-login")
+	resp := send(req)
+	var body struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.RequestID != "failed-login" {
+		t.Fatalf("error body has request id %q: %v", body.RequestID, err)
+	}
+
+	// and so do the bodies of errors handlers return rather than write
+	resp = send(httptest.NewRequest(http.MethodGet, "/missing", nil))
+	body.RequestID = ""
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.RequestID == "" {
+		t.Fatalf("unmatched route body has no request id: %v", err)
+	}
+
+	lines := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not json: %s", line)
+		}
+		if id, ok := entry["request_id"].(string); ok && entry["message"] == "Request" {
+			lines[id] = entry
+		}
+	}
+
+	notes := lines["client-id-1"]
+	if notes["user_id"] != user.ID || notes["route"] != "/api/notes/" || notes["status"] != float64(http.StatusOK) || notes["level"] != "info" {
+		t.Fatalf("unexpected request line %v", notes)
+	}
+	if login := lines["failed-login"]; login["level"] != "warn" || login["body"] == nil {
+		t.Fatalf("unexpected login line %v", login)
+	}
+
+	// secrets never reach the logs
+	for _, secret := range []string{user.Token, "wrong-password"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Fatalf("logs contain a secret: %s", logs.String())
+		}
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	a := newTestApp(t)
+
+	user := a.register("Alice@Example.com")
+	if user.Email != "alice@example.com" || user.Token == "" {
+		t.Fatalf("unexpected registration %+v", user)
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/register", "", fiber.Map{"email": "alice@example.com", "password": testPassword}), http.StatusConflict)
+	a.expect(a.request(http.MethodPost, "/api/register", "", fiber.Map{"email": "not-an-email", "password": testPassword}), http.StatusBadRequest)
+	a.expect(a.request(http.MethodPost, "/api/register", "", fiber.Map{"email": "bob@example.com", "password": "123"}), http.StatusBadRequest)
+
+	a.expect(a.request(http.MethodPost, "/api/login", "", fiber.Map{"email": "alice@example.com", "password": "wrong-password"}), http.StatusUnauthorized)
+	a.expect(a.request(http.MethodPost, "/api/login", "", fiber.Map{"email": "nobody@example.com", "password": testPassword}), http.StatusUnauthorized)
+	a.expect(a.request(http.MethodPost, "/api/login", "", fiber.Map{"email": "", "password": ""}), http.StatusBadRequest)
+
+	var login struct {
+		Token string `json:"token"`
+	}
+	a.decode(a.expect(a.request(http.MethodPost, "/api/login", "", fiber.Map{"email": "alice@example.com", "password": testPassword}), http.StatusCreated), &login)
+
+	a.expect(a.request(http.MethodGet, "/api/protected", login.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodGet, "/api/protected", "not-a-jwt", nil), http.StatusUnauthorized)
+}
+
+func TestNotes(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+
+	note := a.createNote(user, "Groceries")
+	if note.Title != "Groceries" || note.UserID != user.ID {
+		t.Fatalf("unexpected note %+v", note)
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/notes", user.Token, fiber.Map{"title": "  "}), http.StatusBadRequest)
+	a.expect(a.request(http.MethodPost, "/api/notes", user.Token, fiber.Map{"title": strings.Repeat("x", 101)}), http.StatusBadRequest)
+
+	var updated noteBody
+	a.decode(a.expect(a.request(http.MethodPut, "/api/notes/"+note.ID, user.Token, fiber.Map{"title": "Weekly groceries"}), http.StatusOK), &updated)
+	if updated.Title != "Weekly groceries" {
+		t.Fatalf("title not updated: %+v", updated)
+	}
+
+	a.expect(a.request(http.MethodPut, "/api/notes/not-a-uuid", user.Token, fiber.Map{"title": "x"}), http.StatusBadRequest)
+
+	var list struct {
+		Notes []noteBody `json:"notes"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes", user.Token, nil), http.StatusOK), &list)
+	if len(list.Notes) != 1 || list.Notes[0].ID != note.ID {
+		t.Fatalf("unexpected notes %+v", list.Notes)
+	}
+
+	a.expect(a.request(http.MethodDelete, "/api/notes/"+note.ID, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodDelete, "/api/notes/"+note.ID, user.Token, nil), http.StatusNotFound)
+
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes", user.Token, nil), http.StatusOK), &list)
+	if len(list.Notes) != 0 {
+		t.Fatalf("deleted note still listed: %+v", list.Notes)
+	}
+}
+
+func TestTasks(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Chores")
+
+	task := a.createTask(user, note.ID, "Vacuum")
+	if task.NoteID != note.ID || task.Status != "pending" {
+		t.Fatalf("unexpected task %+v", task)
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/tasks", user.Token, fiber.Map{"title": ""}), http.StatusBadRequest)
+
+	var updated taskBody
+	a.decode(a.expect(a.request(http.MethodPut, "/api/tasks/"+task.ID, user.Token, fiber.Map{"status": "completed", "priority": "high"}), http.StatusOK), &updated)
+	if updated.Status != "completed" || updated.Priority != "high" || updated.Title != "Vacuum" {
+		t.Fatalf("unexpected update %+v", updated)
+	}
+
+	var tasks struct {
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/tasks", user.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 1 {
+		t.Fatalf("expected one task, got %+v", tasks.Tasks)
+	}
+
+	a.decode(a.expect(a.request(http.MethodGet, "/api/priorities", user.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 1 || tasks.Tasks[0].ID != task.ID {
+		t.Fatalf("expected the high priority task, got %+v", tasks.Tasks)
+	}
+
+	a.expect(a.request(http.MethodDelete, "/api/tasks/"+task.ID, user.Token, nil), http.StatusOK)
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/tasks", user.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 0 {
+		t.Fatalf("deleted task still listed: %+v", tasks.Tasks)
+	}
+}
+
+func TestHistoryAndRevert(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Draft")
+	task := a.createTask(user, note.ID, "First title")
+
+	a.expect(a.request(http.MethodPut, "/api/notes/"+note.ID, user.Token, fiber.Map{"title": "Final"}), http.StatusOK)
+	a.expect(a.request(http.MethodPut, "/api/tasks/"+task.ID, user.Token, fiber.Map{"title": "Second title"}), http.StatusOK)
+
+	type revisions struct {
+		Revisions []struct {
+			ID     string
+			Action string
+		} `json:"revisions"`
+	}
+
+	// revisions come back newest first, so the last one is the creation
+	var noteHistory revisions
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/history", user.Token, nil), http.StatusOK), &noteHistory)
+	if len(noteHistory.Revisions) != 2 {
+		t.Fatalf("expected two note revisions, got %+v", noteHistory.Revisions)
+	}
+	created := noteHistory.Revisions[len(noteHistory.Revisions)-1]
+
+	var reverted noteBody
+	a.decode(a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/history/"+created.ID+"/revert", user.Token, nil), http.StatusOK), &reverted)
+	if reverted.Title != "Draft" {
+		t.Fatalf("note not reverted: %+v", reverted)
+	}
+
+	var taskHistory revisions
+	a.decode(a.expect(a.request(http.MethodGet, "/api/tasks/"+task.ID+"/history", user.Token, nil), http.StatusOK), &taskHistory)
+	if len(taskHistory.Revisions) != 2 {
+		t.Fatalf("expected two task revisions, got %+v", taskHistory.Revisions)
+	}
+	taskCreated := taskHistory.Revisions[len(taskHistory.Revisions)-1]
+
+	var revertedTask taskBody
+	a.decode(a.expect(a.request(http.MethodPost, "/api/tasks/"+task.ID+"/history/"+taskCreated.ID+"/revert", user.Token, nil), http.StatusOK), &revertedTask)
+	if revertedTask.Title != "First title" {
+		t.Fatalf("task not reverted: %+v", revertedTask)
+	}
+
+	// a revision of one note cannot be applied to another
+	other := a.createNote(user, "Other")
+	a.expect(a.request(http.MethodPost, "/api/notes/"+other.ID+"/history/"+created.ID+"/revert", user.Token, nil), http.StatusNotFound)
+}
+
+func TestActivityAndReads(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Sprint")
+	a.createTask(user, note.ID, "Plan")
+	a.createTask(user, note.ID, "Review")
+
+	var noteActivity struct {
+		Activities []struct{ Type string } `json:"activities"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/activity", user.Token, nil), http.StatusOK), &noteActivity)
+	if len(noteActivity.Activities) != 3 || noteActivity.Activities[len(noteActivity.Activities)-1].Type != "note.created" {
+		t.Fatalf("unexpected note activity %+v", noteActivity.Activities)
+	}
+
+	var feed struct {
+		Summary map[string]int64 `json:"summary"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/activity", user.Token, nil), http.StatusOK), &feed)
+	if feed.Summary["task.created"] != 2 || feed.Summary["note.created"] != 1 {
+		t.Fatalf("unexpected activity summary %+v", feed.Summary)
+	}
+	a.expect(a.request(http.MethodGet, "/api/activity?since=yesterday", user.Token, nil), http.StatusBadRequest)
+
+	// entries sharing a timestamp are neither skipped nor repeated across pages
+	at := time.Now().Add(-time.Minute).Truncate(time.Microsecond)
+	for i := 0; i < 5; i++ {
+		tied := models.Activity{ID: uuid.New(), UserID: uuid.MustParse(user.ID), NoteID: uuid.MustParse(note.ID), Type: "task.created", CreatedAt: at}
+		if err := a.db.Create(&tied).Error; err != nil {
+			t.Fatalf("create activity: %v", err)
+		}
+	}
+	seen := map[string]bool{}
+	cursor := ""
+	for page := 0; page < 10; page++ {
+		var feed struct {
+			Activities []struct{ ID string } `json:"activities"`
+			NextCursor string                `json:"next_cursor"`
+		}
+		a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/activity?limit=2&cursor="+cursor, user.Token, nil), http.StatusOK), &feed)
+		for _, activity := range feed.Activities {
+			if seen[activity.ID] {
+				t.Fatalf("activity %s on two pages", activity.ID)
+			}
+			seen[activity.ID] = true
+		}
+		if cursor = feed.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(seen) != 8 {
+		t.Fatalf("paged through %d of 8 activities", len(seen))
+	}
+	a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/activity?cursor=not-a-cursor", user.Token, nil), http.StatusBadRequest)
+
+	var list struct {
+		Notes []noteBody `json:"notes"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes", user.Token, nil), http.StatusOK), &list)
+	if list.Notes[0].UnreadTasks != 2 {
+		t.Fatalf("expected two unread tasks, got %+v", list.Notes[0])
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/read", user.Token, nil), http.StatusOK)
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes", user.Token, nil), http.StatusOK), &list)
+	if list.Notes[0].UnreadTasks != 0 || list.Notes[0].LastReadAt == nil {
+		t.Fatalf("expected no unread tasks after reading, got %+v", list.Notes[0])
+	}
+}
+
+func TestPresence(t *testing.T) {
+	a := newTestApp(t)
+	alice := a.register("alice@example.com")
+	note := a.createNote(alice, "Shared")
+
+	type viewers struct {
+		Viewers []struct {
+			UserID    string `json:"user_id"`
+			SessionID string `json:"session_id"`
+			Status    string `json:"status"`
+		} `json:"viewers"`
+	}
+
+	var present viewers
+	a.decode(a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/presence", alice.Token, fiber.Map{"session_id": "tab-1"}), http.StatusOK), &present)
+	if len(present.Viewers) != 1 || present.Viewers[0].UserID != alice.ID || present.Viewers[0].Status != "online" {
+		t.Fatalf("unexpected viewers %+v", present.Viewers)
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/presence", alice.Token, fiber.Map{"session_id": "tab-1", "status": "busy"}), http.StatusBadRequest)
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/presence", alice.Token, fiber.Map{}), http.StatusBadRequest)
+
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/presence", alice.Token, fiber.Map{"session_id": "tab-1", "status": "away"}), http.StatusOK)
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/presence", alice.Token, nil), http.StatusOK), &present)
+	if len(present.Viewers) != 1 || present.Viewers[0].Status != "away" {
+		t.Fatalf("unexpected viewers %+v", present.Viewers)
+	}
+
+	a.expect(a.request(http.MethodDelete, "/api/notes/"+note.ID+"/presence?session_id=tab-1", alice.Token, nil), http.StatusOK)
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/presence", alice.Token, nil), http.StatusOK), &present)
+	if len(present.Viewers) != 0 {
+		t.Fatalf("viewer still present after leaving: %+v", present.Viewers)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Batch")
+
+	type result struct {
+		Committed bool `json:"committed"`
+		Partial   bool `json:"partial"`
+		Results   []struct {
+			Status int    `json:"status"`
+			Error  string `json:"error"`
+		} `json:"results"`
+	}
+
+	ops := []fiber.Map{
+		{"op": "create", "type": "task", "note_id": note.ID, "data": fiber.Map{"title": "One"}},
+		{"op": "update", "type": "note", "id": "00000000-0000-0000-0000-000000000000", "data": fiber.Map{"title": "Missing"}},
+		{"op": "create", "type": "task", "note_id": note.ID, "data": fiber.Map{"title": "Two"}},
+	}
+
+	var atomic result
+	a.decode(a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"operations": ops}), http.StatusBadRequest), &atomic)
+	if atomic.Committed || atomic.Results[1].Status != http.StatusNotFound || atomic.Results[2].Status != http.StatusFailedDependency {
+		t.Fatalf("unexpected atomic result %+v", atomic)
+	}
+
+	var tasks struct {
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/tasks", user.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 0 {
+		t.Fatalf("rolled back batch left tasks behind: %+v", tasks.Tasks)
+	}
+
+	var partial result
+	a.decode(a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"mode": "partial", "operations": ops}), http.StatusOK), &partial)
+	if !partial.Committed || !partial.Partial || partial.Results[0].Status != http.StatusCreated || partial.Results[1].Status != http.StatusNotFound {
+		t.Fatalf("unexpected partial result %+v", partial)
+	}
+
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/tasks", user.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 2 {
+		t.Fatalf("expected both tasks from the partial batch, got %+v", tasks.Tasks)
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"mode": "sometimes", "operations": ops}), http.StatusBadRequest)
+	a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"operations": []fiber.Map{}}), http.StatusBadRequest)
+}
+
+// TestBatchSideEffects checks that what an operation records besides the
+// note or task, its history, activity and events, goes with it on rollback
+func TestBatchSideEffects(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Batch")
+
+	recorded := func() map[string]int64 {
+		t.Helper()
+		counts := map[string]int64{}
+		for name, model := range map[string]interface{}{"revisions": &models.Revision{}, "activities": &models.Activity{}, "outbox": &models.OutboxEvent{}, "tasks": &models.Task{}} {
+			var count int64
+			if err := a.db.Model(model).Count(&count).Error; err != nil {
+				t.Fatalf("count %s: %v", name, err)
+			}
+			counts[name] = count
+		}
+		return counts
+	}
+	before := recorded()
+
+	type result struct {
+		Committed bool `json:"committed"`
+		Partial   bool `json:"partial"`
+		Results   []struct {
+			Index  int `json:"index"`
+			Status int `json:"status"`
+		} `json:"results"`
+	}
+	ops := []fiber.Map{
+		{"op": "update", "type": "note", "id": note.ID, "data": fiber.Map{"title": "Renamed"}},
+		{"op": "create", "type": "task", "note_id": note.ID, "data": fiber.Map{"title": "Kept"}},
+		{"op": "delete", "type": "task", "id": "00000000-0000-0000-0000-000000000000"},
+	}
+
+	// atomic: the operations that succeeded before the failure leave nothing behind
+	var atomic result
+	a.decode(a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"operations": ops}), http.StatusBadRequest), &atomic)
+	if atomic.Committed || atomic.Results[0].Status != http.StatusOK || atomic.Results[2].Status != http.StatusNotFound {
+		t.Fatalf("unexpected atomic result %+v", atomic)
+	}
+	if after := recorded(); fmt.Sprint(after) != fmt.Sprint(before) {
+		t.Fatalf("rolled back batch left records behind, before %v after %v", before, after)
+	}
+	var notes struct {
+		Notes []noteBody `json:"notes"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes", user.Token, nil), http.StatusOK), &notes)
+	if notes.Notes[0].Title != "Batch" {
+		t.Fatalf("rolled back rename applied: %+v", notes.Notes[0])
+	}
+
+	// partial: the failed operation is the only one missing, results keep their index
+	var partial result
+	a.decode(a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"mode": "partial", "operations": ops}), http.StatusOK), &partial)
+	if !partial.Committed || !partial.Partial || len(partial.Results) != 3 {
+		t.Fatalf("unexpected partial result %+v", partial)
+	}
+	for i, want := range []int{http.StatusOK, http.StatusCreated, http.StatusNotFound} {
+		if partial.Results[i].Index != i || partial.Results[i].Status != want {
+			t.Fatalf("result %d is %+v, expected status %d", i, partial.Results[i], want)
+		}
+	}
+	after := recorded()
+	// a rename and a new task, each with a revision, an activity and an event
+	for name, added := range map[string]int64{"revisions": 2, "activities": 2, "outbox": 2, "tasks": 1} {
+		if after[name]-before[name] != added {
+			t.Errorf("partial batch added %d %s, expected %d", after[name]-before[name], name, added)
+		}
+	}
+
+	// a partial batch where nothing fails is not reported as partial
+	var clean result
+	a.decode(a.expect(a.request(http.MethodPost, "/api/batch", user.Token, fiber.Map{"mode": "partial", "operations": ops[:2]}), http.StatusOK), &clean)
+	if !clean.Committed || clean.Partial {
+		t.Fatalf("unexpected clean partial result %+v", clean)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Old")
+	task := a.createTask(user, note.ID, "Leftover")
+	keep := a.createNote(user, "Keep")
+	loose := a.createTask(user, keep.ID, "Loose")
+
+	a.expect(a.request(http.MethodDelete, "/api/notes/"+note.ID, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodDelete, "/api/tasks/"+loose.ID, user.Token, nil), http.StatusOK)
+
+	var trash struct {
+		Notes []noteBody `json:"notes"`
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/trash", user.Token, nil), http.StatusOK), &trash)
+	// tasks trashed along with their note are listed under the note
+	if len(trash.Notes) != 1 || len(trash.Tasks) != 1 || trash.Tasks[0].ID != loose.ID {
+		t.Fatalf("unexpected trash %+v", trash)
+	}
+
+	// a task cannot come back while its note is still in the trash
+	a.expect(a.request(http.MethodPost, "/api/trash/tasks/"+task.ID+"/restore", user.Token, nil), http.StatusConflict)
+
+	a.expect(a.request(http.MethodPost, "/api/trash/notes/"+note.ID+"/restore", user.Token, nil), http.StatusOK)
+	var tasks struct {
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/tasks", user.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 1 || tasks.Tasks[0].ID != task.ID {
+		t.Fatalf("restoring the note did not bring back its task: %+v", tasks.Tasks)
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/trash/tasks/"+loose.ID+"/restore", user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodPost, "/api/trash/tasks/"+loose.ID+"/restore", user.Token, nil), http.StatusNotFound)
+
+	// restoring a task shows up in its note's activity like restoring a note
+	var activity struct {
+		Activities []struct{ Type string } `json:"activities"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+keep.ID+"/activity", user.Token, nil), http.StatusOK), &activity)
+	if len(activity.Activities) == 0 || activity.Activities[0].Type != "task.restored" {
+		t.Fatalf("restoring a task was not recorded %+v", activity.Activities)
+	}
+
+	// purging takes along everything recorded about the note and its tasks
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/read", user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodPost, "/api/webhooks", user.Token, fiber.Map{"url": "http://127.0.0.1:1/hook", "note_id": note.ID}), http.StatusCreated)
+
+	a.expect(a.request(http.MethodDelete, "/api/notes/"+note.ID, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodDelete, "/api/trash/tasks/"+task.ID, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodDelete, "/api/trash/notes/"+note.ID, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodDelete, "/api/trash/notes/"+note.ID, user.Token, nil), http.StatusNotFound)
+
+	a.expect(a.request(http.MethodDelete, "/api/tasks/"+loose.ID, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodDelete, "/api/trash", user.Token, nil), http.StatusOK)
+	a.decode(a.expect(a.request(http.MethodGet, "/api/trash", user.Token, nil), http.StatusOK), &trash)
+	if len(trash.Notes) != 0 || len(trash.Tasks) != 0 {
+		t.Fatalf("trash not empty: %+v", trash)
+	}
+
+	orphans := map[string]*gorm.DB{
+		"revisions":  a.db.Model(&models.Revision{}).Where("entity_id IN ?", []string{note.ID, task.ID, loose.ID}),
+		"activities": a.db.Model(&models.Activity{}).Where("note_id=? OR task_id IN ?", note.ID, []string{task.ID, loose.ID}),
+		"reads":      a.db.Model(&models.NoteRead{}).Where("note_id=?", note.ID),
+		"webhooks":   a.db.Model(&models.Webhook{}).Where("note_id=?", note.ID),
+	}
+	for name, query := range orphans {
+		var count int64
+		if err := query.Count(&count).Error; err != nil || count != 0 {
+			t.Errorf("purging left %d %s behind: %v", count, name, err)
+		}
+	}
+}
+
+// TestTrashRestoreTimestamps checks that a note restore brings back the tasks
+// trashed with the note, matched on the shared deleted_at, and no others
+func TestTrashRestoreTimestamps(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Release")
+	withNote := a.createTask(user, note.ID, "Trashed with the note")
+	earlier := a.createTask(user, note.ID, "Trashed on its own")
+
+	a.expect(a.request(http.MethodDelete, "/api/tasks/"+earlier.ID, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodDelete, "/api/notes/"+note.ID, user.Token, nil), http.StatusOK)
+
+	// the stored timestamps are what the restore compares, note and task share one
+	deletedAt := func(model interface{}, id string) time.Time {
+		t.Helper()
+		var row struct{ DeletedAt time.Time }
+		if err := a.db.Unscoped().Model(model).Select("deleted_at").Where("id=?", id).Scan(&row).Error; err != nil {
+			t.Fatalf("read deleted_at: %v", err)
+		}
+		return row.DeletedAt
+	}
+	noteDeleted := deletedAt(&models.Note{}, note.ID)
+	if !deletedAt(&models.Task{}, withNote.ID).Equal(noteDeleted) {
+		t.Fatalf("task trashed with the note has its own timestamp")
+	}
+	if deletedAt(&models.Task{}, earlier.ID).Equal(noteDeleted) {
+		t.Fatalf("task trashed earlier took the note's timestamp")
+	}
+
+	a.expect(a.request(http.MethodPost, "/api/trash/notes/"+note.ID+"/restore", user.Token, nil), http.StatusOK)
+	var tasks struct {
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/notes/"+note.ID+"/tasks", user.Token, nil), http.StatusOK), &tasks)
+	if len(tasks.Tasks) != 1 || tasks.Tasks[0].ID != withNote.ID {
+		t.Fatalf("restore brought back %+v, expected only the task trashed with the note", tasks.Tasks)
+	}
+
+	// the task trashed on its own stays in the trash until restored by itself
+	var trash struct {
+		Tasks []taskBody `json:"tasks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/trash", user.Token, nil), http.StatusOK), &trash)
+	if len(trash.Tasks) != 1 || trash.Tasks[0].ID != earlier.ID {
+		t.Fatalf("unexpected trash after restoring the note %+v", trash.Tasks)
+	}
+	a.expect(a.request(http.MethodPost, "/api/trash/tasks/"+earlier.ID+"/restore", user.Token, nil), http.StatusOK)
+}
+
+func TestWebhooks(t *testing.T) {
+	a := newTestApp(t)
+	user := a.register("alice@example.com")
+	note := a.createNote(user, "Hooks")
+
+	received := make(chan *http.Request, 1)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+
+	a.expect(a.request(http.MethodPost, "/api/webhooks", user.Token, fiber.Map{"url": "ftp://example.com"}), http.StatusBadRequest)
+	a.expect(a.request(http.MethodPost, "/api/webhooks", user.Token, fiber.Map{"url": endpoint.URL, "events": []string{"note.exploded"}}), http.StatusBadRequest)
+
+	var created struct {
+		Webhook struct {
+			ID     string
+			NoteID string
+			Events string
+		} `json:"webhook"`
+		Secret string `json:"secret"`
+	}
+	a.decode(a.expect(a.request(http.MethodPost, "/api/webhooks", user.Token, fiber.Map{"url": endpoint.URL, "note_id": note.ID, "events": []string{"task.created"}}), http.StatusCreated), &created)
+	if created.Secret == "" || created.Webhook.NoteID != note.ID || created.Webhook.Events != "task.created" {
+		t.Fatalf("unexpected webhook %+v", created)
+	}
+	hookPath := "/api/webhooks/" + created.Webhook.ID
+
+	var list struct {
+		Webhooks []map[string]interface{} `json:"webhooks"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/webhooks", user.Token, nil), http.StatusOK), &list)
+	if len(list.Webhooks) != 1 {
+		t.Fatalf("expected one webhook, got %+v", list.Webhooks)
+	}
+	if _, leaked := list.Webhooks[0]["Secret"]; leaked {
+		t.Fatal("webhook secret returned after creation")
+	}
+
+	var delivery struct {
+		Status     string `json:"status"`
+		StatusCode int    `json:"status_code"`
+	}
+	a.decode(a.expect(a.request(http.MethodPost, hookPath+"/test", user.Token, nil), http.StatusOK), &delivery)
+	if delivery.Status != "succeeded" || delivery.StatusCode != http.StatusNoContent {
+		t.Fatalf("unexpected test delivery %+v", delivery)
+	}
+	if r := <-received; r.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected test request content type %q", r.Header.Get("Content-Type"))
+	}
+
+	var deliveries struct {
+		Deliveries []struct{ EventType string } `json:"deliveries"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, hookPath+"/deliveries", user.Token, nil), http.StatusOK), &deliveries)
+	if len(deliveries.Deliveries) != 1 {
+		t.Fatalf("expected the test delivery, got %+v", deliveries.Deliveries)
+	}
+
+	a.expect(a.request(http.MethodPut, hookPath, user.Token, fiber.Map{"active": false}), http.StatusOK)
+	a.expect(a.request(http.MethodPost, hookPath+"/test", user.Token, nil), http.StatusConflict)
+
+	a.expect(a.request(http.MethodDelete, hookPath, user.Token, nil), http.StatusOK)
+	a.expect(a.request(http.MethodGet, hookPath+"/deliveries", user.Token, nil), http.StatusNotFound)
+}
+
+func TestAdminAudit(t *testing.T) {
+	a := newTestApp(t)
+	admin := a.register(testAdminEmail)
+	user := a.register("alice@example.com")
+	a.request(http.MethodPost, "/api/login", "", fiber.Map{"email": "alice@example.com", "password": "wrong-password"})
+
+	for _, path := range []string{"/api/admin/audit", "/api/admin/audit/export", "/api/admin/audit/verify"} {
+		a.expect(a.request(http.MethodGet, path, user.Token, nil), http.StatusForbidden)
+	}
+
+	var entries struct {
+		Entries []struct {
+			Event   string
+			Success bool
+		} `json:"entries"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/admin/audit?email=alice@example.com", admin.Token, nil), http.StatusOK), &entries)
+	if len(entries.Entries) != 2 {
+		t.Fatalf("expected alice's registration and failed login, got %+v", entries.Entries)
+	}
+
+	export := a.expect(a.request(http.MethodGet, "/api/admin/audit/export", admin.Token, nil), http.StatusOK)
+	if lines := strings.Count(strings.TrimSpace(string(export.Body)), "\n") + 1; lines != 3 {
+		t.Fatalf("expected three exported entries, got %d: %s", lines, export.Body)
+	}
+
+	var verify struct {
+		Valid   bool  `json:"valid"`
+		Checked int64 `json:"checked"`
+	}
+	a.decode(a.expect(a.request(http.MethodGet, "/api/admin/audit/verify", admin.Token, nil), http.StatusOK), &verify)
+	if !verify.Valid || verify.Checked != 3 {
+		t.Fatalf("unexpected verification %+v", verify)
+	}
+}
+
+func TestClientAddressBehindProxy(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		proxies []string
+		want    string
+	}{
+		// app.Test connects from 0.0.0.0
+		{"trusted", []string{"0.0.0.0"}, "203.0.113.7"},
+		{"untrusted", []string{"10.0.0.0/8"}, "0.0.0.0"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestApp(t)
+			a.app = fiber.New(fiberConfig(config.ServerConfig{ProxyHeader: "X-Real-IP", TrustedProxies: tc.proxies}))
+			registerRoutes(a.app, config.Config{}, repository.NewGormStore(a.db), a.hub, a.tracker)
+
+			body, _ := json.Marshal(fiber.Map{"email": "alice@example.com", "password": testPassword})
+			req := httptest.NewRequest(http.MethodPost, "/api/register", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Real-IP", "203.0.113.7")
+			if _, err := a.app.Test(req, -1); err != nil {
+				t.Fatal(err)
+			}
+
+			var entry models.AuditLog
+			if err := a.db.First(&entry).Error; err != nil || entry.IP != tc.want {
+				t.Fatalf("audit entry %+v recorded, want ip %s: %v", entry, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	a := newTestApp(t)
+	alice := a.register("alice@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp := a.openStream(ctx, alice)
+	defer resp.Body.Close()
+
+	// changes and presence reach the stream through the app's own broker
+	note := a.createNote(alice, "Streamed")
+	if _, err := outbox.Dispatch(ctx, repository.NewGormStore(a.db), a.hub, 100); err != nil {
+		t.Fatalf("dispatch outbox: %v", err)
+	}
+	a.expect(a.request(http.MethodPost, "/api/notes/"+note.ID+"/presence", alice.Token, fiber.Map{"session_id": "tab-1"}), http.StatusOK)
+
+	scanner := bufio.NewScanner(resp.Body)
+	for _, eventType := range []string{"note.created", "presence.changed"} {
+		for scanner.Scan() {
+			if scanner.Text() == "event: "+eventType {
+				break
+			}
+		}
+		if !scanner.Scan() {
+			t.Fatalf("stream ended before the %s event: %v", eventType, scanner.Err())
+		}
+		if !strings.Contains(scanner.Text(), note.ID) {
+			t.Fatalf("%s event is for another note: %s", eventType, scanner.Text())
+		}
+	}
+}
+
+func TestShutdownClosesStreams(t *testing.T) {
+	a := newTestApp(t)
+	alice := a.register("alice@example.com")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp := a.openStream(ctx, alice)
+	defer resp.Body.Close()
+
+	srv := newServer(a.app, a.db, a.hub)
+	srv.goWorker(func(ctx context.Context) { <-ctx.Done() })
+	if err := srv.shutdown(5 * time.Second); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read stream: %v", err)
+	}
+	if !strings.Contains(string(body), "event: close\ndata: {\"reason\":\"server shutting down\"}") {
+		t.Fatalf("stream ended without a close event: %q", body)
+	}
+
+	if err := a.db.Exec("SELECT 1").Error; err == nil {
+		t.Fatal("database pool still open after shutdown")
+	}
+}
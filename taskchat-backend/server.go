@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"taskchat/events"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	zlog "github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// reason sent to open event streams when the server stops
+const shutdownReason = "server shutting down"
+
+// server owns everything that has to be stopped in order on shutdown
+type server struct {
+	app *fiber.App
+	db  *gorm.DB
+	// the broker whose event streams are closed on shutdown
+	hub *events.Broker
+	// the bus the broker and presence travel over, closed once the workers stop
+	bus events.Bus
+	// sends the spans still buffered to the exporter
+	flushTraces func(context.Context) error
+
+	workerCtx     context.Context
+	cancelWorkers context.CancelFunc
+	workers       sync.WaitGroup
+}
+
+func newServer(app *fiber.App, db *gorm.DB, hub *events.Broker) *server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &server{app: app, db: db, hub: hub, workerCtx: ctx, cancelWorkers: cancel}
+}
+
+// goWorker runs a background worker until shutdown
+func (s *server) goWorker(run func(ctx context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		run(s.workerCtx)
+	}()
+}
+
+// shutdown stops the server within timeout: open event streams are told to
+// reconnect elsewhere, the listener stops accepting connections and in-flight
+// requests finish, the workers flush and stop, the bus and database pool are
+// closed and the buffered trace spans are exported. Whatever is still running
+// at the deadline is abandoned
+func (s *server) shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+
+	// streams never finish on their own, so they have to go before draining
+	s.hub.Close(shutdownReason)
+
+	if err := s.app.ShutdownWithContext(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	zlog.Info().Msg("HTTP server drained")
+
+	// the outbox dispatcher publishes what the drained requests wrote before it stops
+	s.cancelWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		zlog.Info().Msg("Background workers stopped")
+	case <-ctx.Done():
+		errs = append(errs, errors.New("background workers did not stop before the deadline"))
+	}
+
+	if s.bus != nil {
+		if err := s.bus.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if sqlDB, err := s.db.DB(); err == nil {
+		if err := sqlDB.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	zlog.Info().Msg("Database closed")
+
+	// last, so the spans of the drained requests and the final flush are in it
+	if s.flushTraces != nil {
+		if err := s.flushTraces(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,87 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// key the query span is kept under between the before and after callbacks
+const spanKey = "tracing:span"
+
+// RegisterGORM adds a span for every query made on db with the context of a
+// traced request, db.WithContext(c.UserContext()). Queries without one, such
+// as the workers' polling, are not traced so they do not drown out requests
+func RegisterGORM(db *gorm.DB) error {
+	callbacks := db.Callback()
+
+	// gorm's processor types are unexported, so each one is handed over as
+	// the Register methods of its before and after positions
+	type register func(name string, fn func(*gorm.DB)) error
+	for _, op := range []struct {
+		name          string
+		before, after register
+	}{
+		{"create", callbacks.Create().Before("gorm:create").Register, callbacks.Create().After("gorm:create").Register},
+		{"query", callbacks.Query().Before("gorm:query").Register, callbacks.Query().After("gorm:query").Register},
+		{"update", callbacks.Update().Before("gorm:update").Register, callbacks.Update().After("gorm:update").Register},
+		{"delete", callbacks.Delete().Before("gorm:delete").Register, callbacks.Delete().After("gorm:delete").Register},
+		{"row", callbacks.Row().Before("gorm:row").Register, callbacks.Row().After("gorm:row").Register},
+		{"raw", callbacks.Raw().Before("gorm:raw").Register, callbacks.Raw().After("gorm:raw").Register},
+	} {
+		name := op.name
+		if err := op.before("tracing:before_"+name, func(tx *gorm.DB) { startQuerySpan(tx, name) }); err != nil {
+			return err
+		}
+		if err := op.after("tracing:after_"+name, endQuerySpan); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func startQuerySpan(tx *gorm.DB, op string) {
+	ctx := tx.Statement.Context
+	if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+		return
+	}
+
+	// named like "gorm.query tasks" so a slow request shows which table it waited on
+	name := "gorm." + op
+	if table := tx.Statement.Table; table != "" {
+		name += " " + table
+	}
+
+	_, span := tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String(tx.Dialector.Name())),
+	)
+	if table := tx.Statement.Table; table != "" {
+		span.SetAttributes(semconv.DBCollectionName(table))
+	}
+	tx.InstanceSet(spanKey, span)
+}
+
+func endQuerySpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	// the statement keeps its placeholders, the values can be personal data
+	span.SetAttributes(
+		semconv.DBQueryText(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.Statement.RowsAffected),
+	)
+
+	if err := tx.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
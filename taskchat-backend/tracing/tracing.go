@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"taskchat/config"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// name of the instrumentation the spans are reported under
+const instrumentation = "taskchat"
+
+// tracer starts the request and query spans from the global provider, until
+// Setup installs one it is a no-op and spans cost next to nothing
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(instrumentation)
+}
+
+// Setup installs the exporter from cfg as the global tracer provider and the
+// W3C trace context propagator. The returned function flushes the spans still
+// buffered and must be called on shutdown
+func Setup(ctx context.Context, cfg config.TracingConfig) (shutdown func(context.Context) error, err error) {
+
+	// incoming traceparent headers are honoured even with tracing off, so the
+	// ids in the logs still match the caller's trace
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(tracesURL(cfg.Endpoint)))
+	default:
+		err = fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))),
+		// a sampled caller keeps the whole trace, ratio only applies to new ones
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// tracesURL adds the standard traces path to a bare collector url
+func tracesURL(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Path != "" && u.Path != "/") {
+		return endpoint
+	}
+	u.Path = "/v1/traces"
+	return u.String()
+}
+
+// LogHook adds the trace and span ids to log events written with the
+// context of a traced request, log.Error().Ctx(c.UserContext())
+type LogHook struct{}
+
+func (LogHook) Run(e *zerolog.Event, level zerolog.Level, msg string) {
+	span := trace.SpanContextFromContext(e.GetCtx())
+	if !span.IsValid() {
+		return
+	}
+	e.Str("trace_id", span.TraceID().String()).Str("span_id", span.SpanID().String())
+}
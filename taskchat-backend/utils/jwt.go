@@ -2,19 +2,36 @@ package utils
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
+// signing key and token lifetime, set from the config at startup
+var (
+	jwtSecret []byte
+	jwtTTL    = 24 * time.Hour
+)
+
+// ConfigureJWT sets the key tokens are signed and checked with and how long
+// they stay valid, it must be called before serving requests
+func ConfigureJWT(secret string, ttl time.Duration) {
+	jwtSecret = []byte(secret)
+	jwtTTL = ttl
+}
+
+// JWTSecret is the key tokens are signed with
+func JWTSecret() []byte {
+	return jwtSecret
+}
+
 func GenerateJWT(UserID uuid.UUID, email string) (string, error) {
 	// setting up the data that we need to store in the jwt
 	claims := jwt.MapClaims{
 		"user_id": UserID.String(),
 		"email":   email,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
+		"exp":     time.Now().Add(jwtTTL).Unix(),
 		"iat":     time.Now().Unix(),
 		"jti":     uuid.New().String(),
 	}
@@ -22,11 +39,10 @@ func GenerateJWT(UserID uuid.UUID, email string) (string, error) {
 	//create the token with claims
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
+	if len(jwtSecret) == 0 {
 		return "", fmt.Errorf("JWT SECRET not set")
 	}
 
 	// signing the token with the secret
-	return token.SignedString([]byte(secret))
+	return token.SignedString(jwtSecret)
 }
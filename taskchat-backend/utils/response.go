@@ -2,6 +2,9 @@ package utils
 
 import "github.com/gofiber/fiber/v2"
 
+// RequestIDKey is the Locals key the id of the request is kept under
+const RequestIDKey = "request_id"
+
 func Success(c *fiber.Ctx, data interface{}) error {
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"success": true, "data": data})
 }
@@ -11,21 +14,35 @@ func Created(c *fiber.Ctx, data interface{}) error {
 }
 
 func BadRequest(c *fiber.Ctx, message string) error {
-	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"success": false, "error": message})
+	return c.Status(fiber.StatusBadRequest).JSON(ErrorBody(c, message))
 }
 
 func Conflict(c *fiber.Ctx, message string) error {
-	return c.Status(fiber.StatusConflict).JSON(fiber.Map{"success": false, "error": message})
+	return c.Status(fiber.StatusConflict).JSON(ErrorBody(c, message))
 }
 
 func InternalError(c *fiber.Ctx, message string) error {
-	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"success": false, "error": message})
+	return c.Status(fiber.StatusInternalServerError).JSON(ErrorBody(c, message))
 }
 
 func NotFound(c *fiber.Ctx, message string) error {
-	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"success": false, "error": message})
+	return c.Status(fiber.StatusNotFound).JSON(ErrorBody(c, message))
 }
 
 func Unauthorized(c *fiber.Ctx, message string) error {
-	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"success": false, "error": message})
+	return c.Status(fiber.StatusUnauthorized).JSON(ErrorBody(c, message))
+}
+
+func Error(c *fiber.Ctx, status int, message string) error {
+	return c.Status(status).JSON(ErrorBody(c, message))
+}
+
+// ErrorBody is the body of a failed response, with the request id so a
+// client reporting the error can be matched to the server's logs
+func ErrorBody(c *fiber.Ctx, message string) fiber.Map {
+	body := fiber.Map{"success": false, "error": message}
+	if id, ok := c.Locals(RequestIDKey).(string); ok {
+		body["request_id"] = id
+	}
+	return body
 }
@@ -0,0 +1,28 @@
+package utils
+
+import "github.com/gofiber/fiber/v2"
+
+// UnmatchedRoute names requests that no route matched
+const UnmatchedRoute = "unmatched"
+
+// RequestOutcome is the route template and status of a request once the
+// handlers returned err, for middleware that reports on requests. The error
+// handler has not written the status yet when a handler returns an error
+func RequestOutcome(c *fiber.Ctx, err error) (route string, status int) {
+	route, status = c.Route().Path, c.Response().StatusCode()
+	if err == nil {
+		return route, status
+	}
+
+	status = fiber.StatusInternalServerError
+	if e, ok := err.(*fiber.Error); ok {
+		status = e.Code
+
+		// the router answers paths no route matched with these, the route is
+		// then whichever middleware ran last and says nothing about the request
+		if status == fiber.StatusNotFound || status == fiber.StatusMethodNotAllowed {
+			route = UnmatchedRoute
+		}
+	}
+	return route, status
+}
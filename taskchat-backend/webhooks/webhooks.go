@@ -0,0 +1,409 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"taskchat/models"
+	"taskchat/repository"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// attempts per delivery before it is given up on
+	MaxAttempts = 8
+	// consecutive failed attempts before the endpoint is switched off
+	DisableAfterFailures = 20
+
+	// first retry delay, doubled for every attempt after that
+	retryBase = 30 * time.Second
+	retryMax  = 6 * time.Hour
+
+	// how long a claimed delivery is hidden from other dispatchers on top of
+	// the time it can take to send the batch it was claimed in, see leaseFor
+	claimLease = 2 * time.Minute
+
+	requestTimeout = 10 * time.Second
+
+	EventTest = "webhook.test"
+)
+
+// errBlockedTarget is returned when an endpoint resolves to an address
+// webhooks may not reach
+var errBlockedTarget = errors.New("endpoint address is not allowed")
+
+// blockedPrefixes are the ranges outside the public internet that the
+// net.IP checks in blockedAddr do not cover
+var blockedPrefixes = []netip.Prefix{
+	// "this network", 0.0.0.0/8 reaches the local host on linux
+	netip.MustParsePrefix("0.0.0.0/8"),
+	// carrier-grade nat, some clouds serve metadata from it
+	netip.MustParsePrefix("100.64.0.0/10"),
+}
+
+// NewSecret returns a random signing secret for a webhook
+func NewSecret() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Sign returns the signature header value for a payload sent at timestamp.
+// Receivers recompute HMAC-SHA256 over "<timestamp>.<body>" with their secret
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Matches reports whether the webhook's event filter includes eventType
+func Matches(webhook *models.Webhook, eventType string) bool {
+	filters := strings.Split(webhook.Events, ",")
+	return slices.Contains(filters, "*") || slices.Contains(filters, eventType)
+}
+
+// Enqueue queues a delivery of the event for every active webhook of the
+// user that covers the note and subscribes to the event type. Queuing the
+// same event id twice for a webhook is a no-op
+func Enqueue(store repository.Store, eventID, userID, noteID uuid.UUID, eventType string, data interface{}) error {
+	hooks, err := store.Webhooks().ListActive(userID, noteID)
+	if err != nil {
+		return err
+	}
+
+	var deliveries []models.WebhookDelivery
+	for i := range hooks {
+		if !Matches(&hooks[i], eventType) {
+			continue
+		}
+
+		deliveryID := uuid.New()
+		payload, err := newPayload(deliveryID, eventType, data)
+		if err != nil {
+			return err
+		}
+		payload["event_id"] = eventID.String()
+
+		deliveries = append(deliveries, models.WebhookDelivery{
+			ID:            deliveryID,
+			WebhookID:     hooks[i].ID,
+			EventID:       &eventID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        string(models.DeliveryPending),
+			NextAttemptAt: time.Now(),
+		})
+	}
+
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return store.Webhooks().QueueDeliveries(deliveries)
+}
+
+// Service sends deliveries and records how they went. The handlers use it
+// for test events and the dispatcher for everything queued
+type Service struct {
+	store  repository.Store
+	client *http.Client
+}
+
+// NewService sends through a client that refuses loopback, private and
+// link-local addresses unless allowPrivateTargets is set
+func NewService(store repository.Store, allowPrivateTargets bool) *Service {
+	dialer := &net.Dialer{Timeout: requestTimeout}
+	if !allowPrivateTargets {
+		// checked on the address actually dialled, after every lookup and
+		// redirect, so a name that resolves to the internal network is caught
+		dialer.Control = rejectBlocked
+	}
+
+	return &Service{store: store, client: &http.Client{
+		Timeout: requestTimeout,
+		Transport: &http.Transport{
+			// a proxy would be dialled instead of the endpoint and get past the check
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: requestTimeout,
+		},
+	}}
+}
+
+// rejectBlocked is the dialer's Control hook, it runs before each connection
+func rejectBlocked(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || blockedAddr(addrPort.Addr()) {
+		return errBlockedTarget
+	}
+	return nil
+}
+
+func blockedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SendTest delivers a test event to the webhook straight away
+func (s *Service) SendTest(ctx context.Context, webhook *models.Webhook) (*models.WebhookDelivery, error) {
+	store := s.store.WithContext(ctx)
+
+	deliveryID := uuid.New()
+	payload, err := newPayload(deliveryID, EventTest, map[string]interface{}{"webhook_id": webhook.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery := models.WebhookDelivery{
+		ID:            deliveryID,
+		WebhookID:     webhook.ID,
+		EventType:     EventTest,
+		Payload:       payload,
+		Status:        string(models.DeliveryPending),
+		NextAttemptAt: time.Now().Add(leaseFor(1)),
+	}
+	if err := store.Webhooks().CreateDelivery(&delivery); err != nil {
+		return nil, err
+	}
+
+	if err := s.attempt(ctx, store, webhook, &delivery); err != nil {
+		return nil, err
+	}
+
+	return store.Webhooks().FindDelivery(delivery.ID)
+}
+
+// ProcessDue claims up to limit deliveries that are due and attempts them,
+// returning how many were attempted
+func (s *Service) ProcessDue(ctx context.Context, limit int) (int, error) {
+	store := s.store.WithContext(ctx)
+	var due []models.WebhookDelivery
+
+	// claim the rows by pushing their next attempt out, so another instance
+	// running the dispatcher skips them
+	err := store.Transaction(func(tx repository.Store) error {
+		var err error
+		if due, err = tx.Webhooks().ListDue(time.Now(), limit); err != nil || len(due) == 0 {
+			return err
+		}
+
+		ids := make([]uuid.UUID, len(due))
+		for i := range due {
+			ids[i] = due[i].ID
+		}
+		return tx.Webhooks().Reschedule(ids, time.Now().Add(leaseFor(len(due))))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range due {
+		webhook, err := store.Webhooks().Get(due[i].WebhookID)
+		if err != nil {
+			// the webhook was removed after the event was queued
+			due[i].Status = string(models.DeliveryFailed)
+			if err := store.Webhooks().SaveDelivery(&due[i]); err != nil {
+				log.Error().Err(err).Str("delivery_id", due[i].ID.String()).Msg("Failed to fail orphaned webhook delivery")
+			}
+			continue
+		}
+
+		if err := s.attempt(ctx, store, webhook, &due[i]); err != nil {
+			log.Error().Err(err).Str("delivery_id", due[i].ID.String()).Msg("Failed to record webhook attempt")
+		}
+	}
+
+	return len(due), nil
+}
+
+// leaseFor is how long a batch of n deliveries stays claimed. They are sent
+// one after the other, so the lease outlasts every request in the batch
+// timing out and no other instance sends the tail of the batch a second time
+func leaseFor(n int) time.Duration {
+	return time.Duration(n)*requestTimeout + claimLease
+}
+
+// attempt sends one request for the delivery and records the outcome on the
+// delivery, the attempt log and the webhook's failure counter
+func (s *Service) attempt(ctx context.Context, store repository.Store, webhook *models.Webhook, delivery *models.WebhookDelivery) error {
+	if !webhook.Active {
+		delivery.Status = string(models.DeliveryFailed)
+		return store.Webhooks().SaveDelivery(delivery)
+	}
+
+	statusCode, duration, sendErr := s.send(ctx, webhook, delivery)
+
+	// shutting down is not the endpoint's fault, the lease runs out and the
+	// delivery is picked up again
+	if sendErr != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	record := models.WebhookAttempt{
+		ID:         uuid.New(),
+		DeliveryID: delivery.ID,
+		StatusCode: statusCode,
+		DurationMs: duration.Milliseconds(),
+	}
+	if sendErr != nil {
+		record.Error = sendErr.Error()
+	}
+
+	delivery.Attempts++
+
+	return store.Transaction(func(tx repository.Store) error {
+		if err := tx.Webhooks().CreateAttempt(&record); err != nil {
+			return err
+		}
+
+		if sendErr == nil {
+			delivery.Status = string(models.DeliverySucceeded)
+			if err := tx.Webhooks().ResetFailures(webhook); err != nil {
+				return err
+			}
+		} else {
+			if delivery.Attempts >= MaxAttempts {
+				delivery.Status = string(models.DeliveryFailed)
+			} else {
+				delivery.NextAttemptAt = time.Now().Add(retryDelay(delivery.Attempts))
+			}
+
+			if err := recordFailure(tx, webhook); err != nil {
+				return err
+			}
+			if !webhook.Active {
+				delivery.Status = string(models.DeliveryFailed)
+			}
+		}
+
+		return tx.Webhooks().SaveDelivery(delivery)
+	})
+}
+
+// recordFailure bumps the failure counter and switches the webhook off,
+// dropping its queue, once it has failed too many times in a row
+func recordFailure(tx repository.Store, webhook *models.Webhook) error {
+	if err := tx.Webhooks().CountFailure(webhook); err != nil {
+		return err
+	}
+
+	if webhook.FailureCount < DisableAfterFailures || !webhook.Active {
+		return nil
+	}
+
+	log.Warn().Str("webhook_id", webhook.ID.String()).Msg("Webhook disabled after repeated failures")
+	return tx.Webhooks().Disable(webhook, time.Now())
+}
+
+// send posts the signed payload, any non 2xx response counts as a failure
+func (s *Service) send(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery) (int, time.Duration, error) {
+	body, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "taskchat-webhooks/1.0")
+	req.Header.Set("X-Taskchat-Event", delivery.EventType)
+	req.Header.Set("X-Taskchat-Delivery", delivery.ID.String())
+	req.Header.Set("X-Taskchat-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Taskchat-Signature", Sign(webhook.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		// the delivery log is shown to the user, who must not learn what the
+		// network behind the server looks like from it
+		log.Debug().Err(err).Str("delivery_id", delivery.ID.String()).Msg("Webhook request failed")
+		return 0, duration, requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, duration, fmt.Errorf("endpoint responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, duration, nil
+}
+
+// requestError is what the delivery log says about a request that got no
+// response, without the address or dial error underneath
+func requestError(ctx context.Context, err error) error {
+	var netErr net.Error
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, errBlockedTarget):
+		return errBlockedTarget
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errors.New("request timed out")
+	default:
+		return errors.New("could not connect to the endpoint")
+	}
+}
+
+// retryDelay is the exponential backoff after the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase << (attempts - 1)
+	if delay <= 0 || delay > retryMax {
+		return retryMax
+	}
+	return delay
+}
+
+// newPayload is the JSON body posted to the endpoint, its id is the delivery
+// id so receivers can drop retries they have already processed
+func newPayload(deliveryID uuid.UUID, eventType string, data interface{}) (models.JSON, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return nil, err
+	}
+
+	return models.JSON{
+		"id":         deliveryID.String(),
+		"type":       eventType,
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+		"data":       decoded,
+	}, nil
+}
@@ -0,0 +1,143 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"taskchat/models"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBlockedAddr(t *testing.T) {
+	for addr, blocked := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"0.0.0.0":          true,
+		"100.100.100.200":  true,
+		"::1":              true,
+		"fd00:ec2::254":    true,
+		"fe80::1":          true,
+		"::ffff:127.0.0.1": true,
+		"93.184.216.34":    false,
+		"2606:4700::1111":  false,
+	} {
+		if got := blockedAddr(netip.MustParseAddr(addr)); got != blocked {
+			t.Errorf("blockedAddr(%s) = %v, want %v", addr, got, blocked)
+		}
+	}
+}
+
+func TestSendRejectsPrivateTargets(t *testing.T) {
+	var hits atomic.Int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer endpoint.Close()
+
+	delivery := &models.WebhookDelivery{ID: uuid.New(), EventType: EventTest, Payload: models.JSON{}}
+
+	// by address and by a name that resolves to it
+	blocked := NewService(nil, false)
+	for _, url := range []string{endpoint.URL, strings.Replace(endpoint.URL, "127.0.0.1", "localhost", 1)} {
+		status, _, err := blocked.send(context.Background(), &models.Webhook{URL: url, Secret: "s"}, delivery)
+		if !errors.Is(err, errBlockedTarget) || status != 0 {
+			t.Fatalf("send to %s returned %d %v", url, status, err)
+		}
+	}
+	if hits.Load() != 0 {
+		t.Fatal("blocked endpoint was reached")
+	}
+
+	// the opt-in for local testing
+	allowed := NewService(nil, true)
+	if status, _, err := allowed.send(context.Background(), &models.Webhook{URL: endpoint.URL, Secret: "s"}, delivery); err != nil || status != http.StatusNoContent {
+		t.Fatalf("send with private targets allowed returned %d %v", status, err)
+	}
+}
+
+func TestRequestErrorHidesDetails(t *testing.T) {
+	// nothing listens on this port, the dial error names the address
+	_, _, err := NewService(nil, true).send(context.Background(), &models.Webhook{URL: "http://127.0.0.1:1/hook", Secret: "s"}, &models.WebhookDelivery{ID: uuid.New()})
+	if err == nil || strings.Contains(err.Error(), "127.0.0.1") || strings.Contains(err.Error(), "refused") {
+		t.Fatalf("request error leaks details: %v", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// receivers check the signature with their own hmac, so it must not drift
+	body := []byte(`{"type":"note.created"}`)
+	want := "sha256=5cd4e97b6b54a09b89fbbcfef731e19cb5bff0b14b3efa947a9b66d0496a0857"
+	if got := Sign("whsec_test", 1700000000, body); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+
+	// the timestamp is signed too, so a captured request cannot be replayed later
+	if Sign("whsec_test", 1700000001, body) == want || Sign("other", 1700000000, body) == want {
+		t.Fatal("signature does not cover the timestamp and secret")
+	}
+}
+
+func TestSendSignsTheBody(t *testing.T) {
+	var header http.Header
+	var body []byte
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer endpoint.Close()
+
+	delivery := &models.WebhookDelivery{ID: uuid.New(), EventType: EventTest, Payload: models.JSON{"id": "1"}}
+	if _, _, err := NewService(nil, true).send(context.Background(), &models.Webhook{URL: endpoint.URL, Secret: "whsec_test"}, delivery); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	timestamp, err := strconv.ParseInt(header.Get("X-Taskchat-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp header %q: %v", header.Get("X-Taskchat-Timestamp"), err)
+	}
+	if header.Get("X-Taskchat-Signature") != Sign("whsec_test", timestamp, body) {
+		t.Fatal("signature does not match the body that was sent")
+	}
+	if header.Get("X-Taskchat-Delivery") != delivery.ID.String() || header.Get("X-Taskchat-Event") != EventTest {
+		t.Fatalf("unexpected headers %v", header)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  retryBase,
+		2:  2 * retryBase,
+		3:  4 * retryBase,
+		5:  16 * retryBase,
+		10: 512 * retryBase,
+		// capped, also once the shift overflows
+		11:  retryMax,
+		100: retryMax,
+	} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+
+	// a delivery that keeps failing gives up well within a day
+	var total time.Duration
+	for attempts := 1; attempts < MaxAttempts; attempts++ {
+		total += retryDelay(attempts)
+	}
+	if total > 24*time.Hour {
+		t.Fatalf("retries span %s", total)
+	}
+}
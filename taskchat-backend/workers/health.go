@@ -0,0 +1,99 @@
+package workers
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// a worker that has not finished a round in this many intervals is stuck
+const staleAfterIntervals = 3
+
+// WorkerHealth is what readiness reports about one background worker
+type WorkerHealth struct {
+	Name     string     `json:"name"`
+	Status   string     `json:"status"`
+	Interval string     `json:"interval"`
+	LastRun  *time.Time `json:"last_run,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type workerState struct {
+	interval time.Duration
+	started  time.Time
+	lastRun  time.Time
+	lastErr  error
+	stopped  bool
+}
+
+// registry remembers how each running worker's last round went
+type registry struct {
+	mu      sync.Mutex
+	workers map[string]*workerState
+}
+
+var health = &registry{workers: map[string]*workerState{}}
+
+// start registers a worker that is about to run its first round
+func (r *registry) start(name string, interval time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers[name] = &workerState{interval: interval, started: time.Now()}
+}
+
+// finish records the outcome of a round
+func (r *registry) finish(name string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.workers[name]; ok {
+		w.lastRun, w.lastErr = time.Now(), err
+	}
+}
+
+// stop marks a worker that returned because its context was cancelled
+func (r *registry) stop(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w, ok := r.workers[name]; ok {
+		w.stopped = true
+	}
+}
+
+// Health reports every worker started in this process. A worker is ok when
+// its last round succeeded within a few intervals, failing when that round
+// returned an error, and stale or stopped when it is no longer running.
+// healthy is false unless every worker is ok
+func Health() (report []WorkerHealth, healthy bool) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	healthy = true
+	for name, w := range health.workers {
+		item := WorkerHealth{Name: name, Status: "ok", Interval: w.interval.String()}
+
+		// a worker that has not finished its first round yet is measured from its start
+		last := w.started
+		if !w.lastRun.IsZero() {
+			lastRun := w.lastRun
+			item.LastRun = &lastRun
+			last = lastRun
+		}
+
+		switch {
+		case w.stopped:
+			item.Status = "stopped"
+		case time.Since(last) > staleAfterIntervals*w.interval:
+			item.Status = "stale"
+		case w.lastErr != nil:
+			item.Status = "failing"
+			item.Error = w.lastErr.Error()
+		}
+		if item.Status != "ok" {
+			healthy = false
+		}
+		report = append(report, item)
+	}
+
+	sort.Slice(report, func(i, j int) bool { return report[i].Name < report[j].Name })
+	return report, healthy
+}
@@ -0,0 +1,79 @@
+package workers
+
+import (
+	"context"
+	"taskchat/events"
+	"taskchat/outbox"
+	"taskchat/repository"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// events published per round
+	outboxBatchSize = 100
+	// published events are kept this long for debugging
+	outboxRetention = 7 * 24 * time.Hour
+	// longest the last round may take on shutdown
+	outboxFlushTimeout = 5 * time.Second
+)
+
+// RunOutboxDispatcher publishes outbox events every interval until ctx is
+// cancelled, flushing what is left on the way out, and clears out old
+// published events once an hour
+func RunOutboxDispatcher(ctx context.Context, store repository.Store, hub *events.Broker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	cleanup := time.NewTicker(time.Hour)
+	defer cleanup.Stop()
+
+	health.start("outbox", interval)
+	defer health.stop("outbox")
+
+	for {
+		var roundErr error
+		for ctx.Err() == nil {
+			n, err := outbox.Dispatch(ctx, store, hub, outboxBatchSize)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to dispatch outbox events")
+				roundErr = err
+				break
+			}
+			if n < outboxBatchSize {
+				break
+			}
+		}
+		health.finish("outbox", roundErr)
+
+		select {
+		case <-ctx.Done():
+			flushOutbox(store, hub)
+			return
+		case <-cleanup.C:
+			if _, err := outbox.Cleanup(store.WithContext(ctx), time.Now().Add(-outboxRetention)); err != nil {
+				log.Error().Err(err).Msg("Failed to clean up outbox")
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushOutbox publishes what the last requests wrote before the process
+// exits, anything left is picked up by the next instance to start
+func flushOutbox(store repository.Store, hub *events.Broker) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxFlushTimeout)
+	defer cancel()
+
+	for ctx.Err() == nil {
+		n, err := outbox.Dispatch(ctx, store, hub, outboxBatchSize)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to flush outbox events")
+			return
+		}
+		if n < outboxBatchSize {
+			return
+		}
+	}
+}
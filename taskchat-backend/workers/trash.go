@@ -0,0 +1,35 @@
+package workers
+
+import (
+	"context"
+	"taskchat/repository"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RunTrashPurger empties the trash of anything older than retention every
+// interval until ctx is cancelled
+func RunTrashPurger(ctx context.Context, store repository.Store, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	health.start("trash", interval)
+	defer health.stop("trash")
+
+	for {
+		purged, err := store.WithContext(ctx).Notes().PurgeExpired(time.Now().Add(-retention))
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to purge trash")
+		} else if purged > 0 {
+			log.Info().Int64("rows", purged).Msg("Purged expired trash")
+		}
+		health.finish("trash", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package workers
+
+import (
+	"context"
+	"taskchat/webhooks"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// deliveries claimed per round
+const webhookBatchSize = 50
+
+// RunWebhookDispatcher sends due webhook deliveries every interval until ctx
+// is cancelled, draining the queue before it sleeps again
+func RunWebhookDispatcher(ctx context.Context, hooks *webhooks.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	health.start("webhooks", interval)
+	defer health.stop("webhooks")
+
+	for {
+		var roundErr error
+		for ctx.Err() == nil {
+			n, err := hooks.ProcessDue(ctx, webhookBatchSize)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to process webhook deliveries")
+				roundErr = err
+				break
+			}
+			if n < webhookBatchSize {
+				break
+			}
+		}
+		health.finish("webhooks", roundErr)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}